@@ -0,0 +1,237 @@
+package zbus
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// blockSlack is added to the timeout of blocking commands, so they don't
+// time out on the client before the server replies
+const blockSlack = 5 * time.Second
+
+// brokerAddress is a parsed broker address
+type brokerAddress struct {
+	network string
+	host    string
+	opts    []redis.DialOption
+	// sentinel is set if the address is a sentinel group, host is then empty
+	sentinel *sentinel
+}
+
+// parseAddress parses a broker address of the form
+//
+//	tcp://[user[:password]@]host:port[/db][?options]
+//	rediss://[user[:password]@]host:port[/db][?options]
+//	unix://[user[:password]@]/path/to/socket[?options]
+//	sentinel://[user[:password]@]host[:port][,host[:port]...]/master[?options]
+//
+// rediss connects with TLS. If only a user is set, it's used as the password. A sentinel
+// address connects to the current master of the group, the user and password are the
+// master credentials. Options are
+//
+//	db: the database number
+//	dial_timeout, read_timeout, write_timeout: timeouts as durations (for example 5s)
+//	sentinel_user, sentinel_password: credentials of the sentinels
+//	tls_ca: path to the CA certificate used to verify the server certificate
+//	tls_cert, tls_key: paths to the client certificate and key
+//	tls_skip_verify: true to skip the server certificate verification
+func parseAddress(address string) (brokerAddress, error) {
+	var addr brokerAddress
+
+	// url.Parse can't parse a list of hosts, they are split separately
+	var hosts string
+	if strings.HasPrefix(address, "sentinel://") {
+		address, hosts = sentinelHosts(address)
+	}
+
+	u, err := url.Parse(address)
+	if err != nil {
+		return addr, err
+	}
+
+	path := strings.TrimPrefix(u.Path, "/")
+	switch u.Scheme {
+	case "tcp", "redis", "rediss":
+		addr.network, addr.host = "tcp", u.Host
+	case "unix":
+		addr.network, addr.host = "unix", u.Path
+		path = ""
+	case "sentinel":
+		if len(path) == 0 {
+			return addr, fmt.Errorf("missing sentinel master name")
+		}
+
+		addr.network, addr.sentinel = "tcp", &sentinel{master: path}
+		for _, host := range strings.Split(hosts, ",") {
+			if len(host) == 0 {
+				continue
+			}
+
+			name, port, err := net.SplitHostPort(host)
+			if err != nil {
+				// no port, an IPv6 address must still be in brackets
+				name, port, err = net.SplitHostPort(host + ":" + sentinelPort)
+			}
+
+			if _, perr := strconv.ParseUint(port, 10, 16); err != nil || perr != nil || len(name) == 0 {
+				return addr, fmt.Errorf("invalid sentinel address '%s'", host)
+			}
+
+			addr.sentinel.addrs = append(addr.sentinel.addrs, net.JoinHostPort(name, port))
+		}
+
+		if len(addr.sentinel.addrs) == 0 {
+			return addr, fmt.Errorf("missing sentinel addresses")
+		}
+		path = ""
+	default:
+		return addr, fmt.Errorf("unknown scheme '%s' expecting tcp, rediss, unix or sentinel", u.Scheme)
+	}
+
+	if u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			addr.opts = append(addr.opts, redis.DialUsername(u.User.Username()), redis.DialPassword(password))
+		} else {
+			addr.opts = append(addr.opts, redis.DialPassword(u.User.Username()))
+		}
+	}
+
+	query := u.Query()
+	if db := query.Get("db"); len(db) != 0 {
+		path = db
+	}
+
+	if len(path) != 0 {
+		db, err := strconv.Atoi(path)
+		if err != nil {
+			return addr, fmt.Errorf("invalid database '%s'", path)
+		}
+
+		addr.opts = append(addr.opts, redis.DialDatabase(db))
+	}
+
+	var config tls.Config
+	var sentinelUser, sentinelPassword string
+	for name := range query {
+		value := query.Get(name)
+		switch name {
+		case "db":
+		case "dial_timeout", "read_timeout", "write_timeout":
+			timeout, err := time.ParseDuration(value)
+			if err != nil {
+				return addr, fmt.Errorf("invalid %s '%s'", name, value)
+			}
+
+			var opt redis.DialOption
+			switch name {
+			case "dial_timeout":
+				opt = redis.DialConnectTimeout(timeout)
+			case "read_timeout":
+				opt = redis.DialReadTimeout(timeout)
+			case "write_timeout":
+				opt = redis.DialWriteTimeout(timeout)
+			}
+
+			addr.opts = append(addr.opts, opt)
+			if addr.sentinel != nil {
+				addr.sentinel.opts = append(addr.sentinel.opts, opt)
+			}
+		case "sentinel_user":
+			sentinelUser = value
+		case "sentinel_password":
+			sentinelPassword = value
+		case "tls_ca":
+			pem, err := ioutil.ReadFile(value)
+			if err != nil {
+				return addr, fmt.Errorf("failed to read CA certificate: %s", err)
+			}
+
+			config.RootCAs = x509.NewCertPool()
+			if !config.RootCAs.AppendCertsFromPEM(pem) {
+				return addr, fmt.Errorf("invalid CA certificate '%s'", value)
+			}
+		case "tls_cert", "tls_key":
+			// loaded together below
+		case "tls_skip_verify":
+			skip, err := strconv.ParseBool(value)
+			if err != nil {
+				return addr, fmt.Errorf("invalid %s '%s'", name, value)
+			}
+
+			config.InsecureSkipVerify = skip
+		default:
+			return addr, fmt.Errorf("unknown option '%s'", name)
+		}
+	}
+
+	cert, key := query.Get("tls_cert"), query.Get("tls_key")
+	if len(cert) != 0 || len(key) != 0 {
+		certificate, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return addr, fmt.Errorf("failed to load client certificate: %s", err)
+		}
+
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	if len(sentinelUser) != 0 || len(sentinelPassword) != 0 {
+		if addr.sentinel == nil {
+			return addr, fmt.Errorf("sentinel options are only supported by the sentinel scheme")
+		}
+
+		if len(sentinelUser) != 0 {
+			addr.sentinel.opts = append(addr.sentinel.opts, redis.DialUsername(sentinelUser))
+		}
+		addr.sentinel.opts = append(addr.sentinel.opts, redis.DialPassword(sentinelPassword))
+	}
+
+	if u.Scheme == "rediss" {
+		addr.opts = append(addr.opts, redis.DialUseTLS(true), redis.DialTLSConfig(&config))
+	} else if config.RootCAs != nil || len(config.Certificates) != 0 || config.InsecureSkipVerify {
+		return addr, fmt.Errorf("tls options are only supported by the rediss scheme")
+	}
+
+	return addr, nil
+}
+
+// sentinelHosts cuts the list of hosts out of a sentinel address. It returns
+// the address with a single placeholder host, and the list of hosts.
+func sentinelHosts(address string) (string, string) {
+	const scheme = "sentinel://"
+	rest := address[len(scheme):]
+
+	end := strings.IndexAny(rest, "/?#")
+	if end < 0 {
+		end = len(rest)
+	}
+
+	authority := rest[:end]
+	at := strings.LastIndex(authority, "@") + 1
+	return scheme + authority[:at] + "sentinel" + rest[end:], authority[at:]
+}
+
+// dial connects to the broker with the address options followed by extra. For a
+// sentinel group, it connects to the current master. extra options are also used
+// to connect to the sentinels.
+func (a *brokerAddress) dial(extra ...redis.DialOption) (redis.Conn, error) {
+	opts := append(append([]redis.DialOption{}, a.opts...), extra...)
+	if a.sentinel != nil {
+		return a.sentinel.dial(opts, extra...)
+	}
+
+	return redis.Dial(a.network, a.host, opts...)
+}
+
+// block runs a blocking command that waits up to timeout for a result
+func block(con redis.Conn, timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	return redis.DoWithTimeout(con, timeout+blockSlack, cmd, args...)
+}
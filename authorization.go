@@ -0,0 +1,78 @@
+package zbus
+
+import (
+	"context"
+	"fmt"
+)
+
+// ErrForbidden is returned if the server rejected the request because the
+// caller is not allowed to call the method
+var ErrForbidden = fmt.Errorf("caller is not allowed")
+
+type callerKey struct{}
+
+// withCaller returns a context that carries the caller identity
+func withCaller(ctx context.Context, caller string) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+// Caller returns the identity of the caller of a method, given the context the method
+// is called with. The identity is the id of the key the request is signed with, it's
+// empty if the server doesn't verify the requests signatures.
+func Caller(ctx context.Context) string {
+	caller, _ := ctx.Value(callerKey{}).(string)
+	return caller
+}
+
+// Caller returns the identity of the request caller, as authenticated by the
+// server. It's empty if the request is not authenticated.
+func (m *Request) Caller() string {
+	return m.caller
+}
+
+// WithAllowedCallers only allows callers to call method. An empty method name applies
+// to all methods of the object. Calls from other callers fail with ErrForbidden. The
+// callers are the ids of the keys the requests are signed with, so the server must
+// verify the requests signatures (see WithKeyring).
+func WithAllowedCallers(method string, callers ...string) ObjectOption {
+	return func(cfg *objectConfig) {
+		if cfg.callers == nil {
+			cfg.callers = make(map[string]map[string]struct{})
+		}
+
+		allowed, ok := cfg.callers[method]
+		if !ok {
+			allowed = make(map[string]struct{})
+			cfg.callers[method] = allowed
+		}
+
+		for _, caller := range callers {
+			allowed[caller] = struct{}{}
+		}
+	}
+}
+
+// authorize checks if the request caller is allowed to call the method
+func (s *BaseServer) authorize(request *Request) error {
+	s.m.RLock()
+	obj, ok := s.objects[request.Object]
+	s.m.RUnlock()
+
+	if !ok {
+		return nil
+	}
+
+	// object wide callers are stored under the empty method name
+	for _, name := range []string{"", request.Method} {
+		allowed, ok := obj.config.callers[name]
+		if !ok {
+			continue
+		}
+
+		if _, ok := allowed[request.caller]; !ok || len(request.caller) == 0 {
+			return ErrForbidden
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package zbus
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type identity struct{}
+
+func (i *identity) WhoAmI(ctx context.Context) string {
+	return Caller(ctx)
+}
+
+func (i *identity) Destroy() {}
+
+func TestBaseServerAllowedCallers(t *testing.T) {
+	s := BaseServer{}
+
+	id := ObjectID{Name: "identity"}
+	require.NoError(t, s.RegisterWith(id, &identity{},
+		WithAllowedCallers("", "storage", "admin"),
+		WithAllowedCallers("Destroy", "admin"),
+	))
+
+	ctx, shutdown := context.WithCancel(context.Background())
+	defer shutdown()
+
+	responses := make(chan *Response, 1)
+	cb := func(request *Request, response *Response) {
+		responses <- response
+	}
+
+	var wg sync.WaitGroup
+	feed := s.Start(ctx, &wg, 1, cb)
+
+	call := func(caller, method string) *Response {
+		request, err := NewRequest("id", "reply-to", id, method)
+		require.NoError(t, err)
+		request.caller = caller
+
+		feed <- request
+		select {
+		case response := <-responses:
+			return response
+		case <-time.After(1 * time.Second):
+			t.Fatal("request was not processed")
+		}
+
+		return nil
+	}
+
+	response := call("storage", "WhoAmI")
+	require.Nil(t, response.Error)
+	var caller string
+	require.NoError(t, response.Unmarshal(&Loader{&caller}))
+	require.Equal(t, "storage", caller)
+
+	response = call("storage", "Destroy")
+	require.NotNil(t, response.Error)
+	require.Equal(t, ErrForbidden, protocolError(*response.Error))
+
+	response = call("admin", "Destroy")
+	require.Nil(t, response.Error)
+
+	// unauthenticated callers
+	response = call("", "WhoAmI")
+	require.NotNil(t, response.Error)
+	require.Equal(t, ErrForbidden.Error(), *response.Error)
+
+	shutdown()
+	wg.Wait()
+}
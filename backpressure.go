@@ -0,0 +1,181 @@
+package zbus
+
+import (
+	"context"
+	"reflect"
+	"sync/atomic"
+)
+
+// OverflowPolicy defines what happens to stream values once the
+// stream consumer is too slow to keep up with the producer
+type OverflowPolicy string
+
+const (
+	// OverflowBlock blocks the producer until the consumer is ready
+	OverflowBlock OverflowPolicy = "block"
+	// OverflowDropNewest drops new values once the buffer is full
+	OverflowDropNewest OverflowPolicy = "drop-newest"
+	// OverflowDropOldest drops the oldest buffered value to make room
+	// for the new one once the buffer is full
+	OverflowDropOldest OverflowPolicy = "drop-oldest"
+	// OverflowCoalesce only keeps the latest value, pending values are
+	// replaced by newer ones
+	OverflowCoalesce OverflowPolicy = "coalesce-latest"
+)
+
+// BackPressure is a stream back pressure policy. It also counts the values
+// dropped by the policy, so a single BackPressure should be used per stream
+// to get accurate counters.
+type BackPressure struct {
+	// dropped is accessed atomically, keep it first
+	// for 64-bit alignment
+	dropped uint64
+
+	// Policy applied once the buffer is full
+	Policy OverflowPolicy
+	// Buffer is the number of values buffered before the policy
+	// applies. Ignored by the coalesce policy.
+	Buffer uint
+}
+
+// Dropped returns the number of values dropped (or replaced) by the policy
+func (b *BackPressure) Dropped() uint64 {
+	return atomic.LoadUint64(&b.dropped)
+}
+
+func (b *BackPressure) size() int {
+	if b.Policy == OverflowCoalesce || b.Buffer == 0 {
+		return 1
+	}
+
+	return int(b.Buffer)
+}
+
+// push adds v to queue according to the policy
+func (b *BackPressure) push(queue []reflect.Value, v reflect.Value) []reflect.Value {
+	if len(queue) < b.size() {
+		return append(queue, v)
+	}
+
+	atomic.AddUint64(&b.dropped, 1)
+	switch b.Policy {
+	case OverflowDropOldest:
+		return append(queue[1:], v)
+	case OverflowCoalesce:
+		queue[len(queue)-1] = v
+	}
+
+	// drop newest
+	return queue
+}
+
+// forward sends the values received from in to out according to the policy. It
+// keeps receiving from in (unless the block policy is full), so values only wait
+// in the policy buffer, and the policy applies up to the consumer of out. decode
+// converts the received values before they are buffered, nil keeps them as is.
+// out is closed once in is closed and all buffered values are consumed, or ctx
+// is cancelled.
+func (b *BackPressure) forward(ctx context.Context, in, out reflect.Value, decode func(reflect.Value) reflect.Value) {
+	defer out.Close()
+
+	done := reflect.ValueOf(ctx.Done())
+	var queue []reflect.Value
+	for open := true; open || len(queue) > 0; {
+		cases := []reflect.SelectCase{{Dir: reflect.SelectRecv, Chan: done}}
+		recv, send := -1, -1
+		if open && (b.Policy != OverflowBlock || len(queue) < b.size()) {
+			recv = len(cases)
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: in})
+		}
+
+		if len(queue) > 0 {
+			send = len(cases)
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectSend, Chan: out, Send: queue[0]})
+		}
+
+		chosen, v, ok := reflect.Select(cases)
+		switch chosen {
+		case 0:
+			return
+		case recv:
+			if !ok {
+				open = false
+				continue
+			}
+
+			if decode != nil {
+				v = decode(v)
+			}
+			queue = b.push(queue, v)
+		case send:
+			queue[0] = reflect.Value{}
+			queue = queue[1:]
+		}
+	}
+}
+
+// pipe forwards the values of in to the returned channel according to the
+// policy. The returned channel is closed once in is closed and all buffered
+// values are consumed, or ctx is cancelled.
+func (b *BackPressure) pipe(ctx context.Context, in <-chan interface{}) <-chan interface{} {
+	if b.Policy == OverflowBlock && b.Buffer == 0 {
+		return in
+	}
+
+	out := make(chan interface{})
+	go b.forward(ctx, reflect.ValueOf(in), reflect.ValueOf(out), nil)
+	return out
+}
+
+type backPressureKey struct{}
+
+// WithBackPressure returns a context that makes all generated stub streams opened
+// with it apply the back pressure policy b. The default policy of stubs is to drop
+// new events if the consumer is not ready to receive them.
+func WithBackPressure(ctx context.Context, b *BackPressure) context.Context {
+	return context.WithValue(ctx, backPressureKey{}, b)
+}
+
+// backPressure returns the back pressure policy associated with ctx
+func backPressure(ctx context.Context) (*BackPressure, bool) {
+	b, ok := ctx.Value(backPressureKey{}).(*BackPressure)
+	return b, ok
+}
+
+// clientBackPressure returns the back pressure policy of client streams
+// opened with ctx
+func clientBackPressure(ctx context.Context) *BackPressure {
+	b, ok := backPressure(ctx)
+	if !ok {
+		b = &BackPressure{Policy: OverflowDropNewest, Buffer: 1}
+	}
+
+	return b
+}
+
+// ApplyBackPressure forwards events from in according to the back pressure policy
+// associated with ctx
+func ApplyBackPressure(ctx context.Context, in <-chan Event) <-chan Event {
+	out := make(chan Event)
+	go clientBackPressure(ctx).forward(ctx, reflect.ValueOf(in), reflect.ValueOf(out), nil)
+	return out
+}
+
+// ForwardEvents decodes the events received from in with codec, and sends them to ch
+// according to the back pressure policy associated with ctx. ch must be a channel of
+// the decoded type, it's closed once in is closed or ctx is cancelled. It panics if an
+// event can't be decoded. It's used by the generated stubs, so the policy applies to
+// the channel returned to the caller.
+func ForwardEvents(ctx context.Context, codec Codec, in <-chan Event, ch interface{}) {
+	out := reflect.ValueOf(ch)
+	elem := out.Type().Elem()
+
+	clientBackPressure(ctx).forward(ctx, reflect.ValueOf(in), out, func(v reflect.Value) reflect.Value {
+		obj := reflect.New(elem)
+		if err := v.Interface().(Event).Decode(codec, obj.Interface()); err != nil {
+			panic(err)
+		}
+
+		return obj.Elem()
+	})
+}
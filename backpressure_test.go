@@ -0,0 +1,125 @@
+package zbus
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// produce sends values to the pipe of b while nothing is consumed, then
+// returns everything the pipe delivers
+func produce(t *testing.T, b *BackPressure, values ...interface{}) []interface{} {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	in := make(chan interface{})
+	out := b.pipe(ctx, in)
+	for _, v := range values {
+		in <- v
+	}
+	close(in)
+
+	var results []interface{}
+	for v := range out {
+		results = append(results, v)
+	}
+
+	return results
+}
+
+func TestBackPressureDropNewest(t *testing.T) {
+	b := &BackPressure{Policy: OverflowDropNewest, Buffer: 2}
+	require.Equal(t, []interface{}{1, 2}, produce(t, b, 1, 2, 3, 4))
+	require.EqualValues(t, 2, b.Dropped())
+}
+
+func TestBackPressureDropOldest(t *testing.T) {
+	b := &BackPressure{Policy: OverflowDropOldest, Buffer: 2}
+	require.Equal(t, []interface{}{3, 4}, produce(t, b, 1, 2, 3, 4))
+	require.EqualValues(t, 2, b.Dropped())
+}
+
+func TestBackPressureCoalesce(t *testing.T) {
+	b := &BackPressure{Policy: OverflowCoalesce}
+	require.Equal(t, []interface{}{4}, produce(t, b, 1, 2, 3, 4))
+	require.EqualValues(t, 3, b.Dropped())
+}
+
+func TestBackPressureBlock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	b := &BackPressure{Policy: OverflowBlock, Buffer: 2}
+	in := make(chan interface{})
+	out := b.pipe(ctx, in)
+
+	in <- 1
+	in <- 2
+	select {
+	case in <- 3:
+		t.Fatal("expected producer to block")
+	default:
+	}
+
+	require.Equal(t, 1, <-out)
+	in <- 3
+	close(in)
+	require.Equal(t, 2, <-out)
+	require.Equal(t, 3, <-out)
+	_, ok := <-out
+	require.False(t, ok)
+	require.EqualValues(t, 0, b.Dropped())
+}
+
+func TestApplyBackPressure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	b := &BackPressure{Policy: OverflowCoalesce}
+	in := make(chan Event)
+	out := ApplyBackPressure(WithBackPressure(ctx, b), in)
+
+	in <- Event("a")
+	in <- Event("b")
+	in <- Event("c")
+	close(in)
+
+	var events []Event
+	for event := range out {
+		events = append(events, event)
+	}
+
+	require.Equal(t, []Event{Event("c")}, events)
+	require.EqualValues(t, 2, b.Dropped())
+}
+
+func TestForwardEvents(t *testing.T) {
+	burst := func(b *BackPressure) []int {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		in := make(chan Event)
+		ch := make(chan int)
+		go ForwardEvents(WithBackPressure(ctx, b), MsgPack, in, ch)
+
+		// nothing is received while the burst is sent
+		for i := 0; i < 100; i++ {
+			data, err := MsgPack.Marshal(i)
+			require.NoError(t, err)
+			in <- Event(data)
+		}
+		close(in)
+
+		var values []int
+		for v := range ch {
+			values = append(values, v)
+		}
+		return values
+	}
+
+	// no stale value is delivered before the latest one
+	require.Equal(t, []int{99}, burst(&BackPressure{Policy: OverflowCoalesce}))
+	require.Equal(t, []int{97, 98, 99}, burst(&BackPressure{Policy: OverflowDropOldest, Buffer: 3}))
+	require.Equal(t, []int{0, 1, 2}, burst(&BackPressure{Policy: OverflowDropNewest, Buffer: 3}))
+}
@@ -0,0 +1,297 @@
+package zbus
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"reflect"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/google/uuid"
+)
+
+const (
+	blobPrefix = "zbus.blob"
+	// blobChunkSize is the max size of a blob chunk. []byte arguments
+	// and returns larger than a chunk are sent as blobs.
+	blobChunkSize = 1024 * 1024
+)
+
+var (
+	// ErrCorruptedBlob is returned when reading a blob that is incomplete
+	// or doesn't match its checksum
+	ErrCorruptedBlob = fmt.Errorf("blob is corrupted or has expired")
+
+	readerType = reflect.TypeOf((*io.Reader)(nil)).Elem()
+	bytesType  = reflect.TypeOf([]byte(nil))
+)
+
+// Blob is a reference to a large payload that is transferred out of band
+// in bounded size chunks
+type Blob struct {
+	ID string
+	// Size is the total size of the content
+	Size int64
+	// Chunks is the number of chunks
+	Chunks int
+	// Hash is the sha256 of the content
+	Hash []byte
+}
+
+// BlobStore stores blobs content
+type BlobStore interface {
+	// Put stores the content of r and returns its reference
+	Put(r io.Reader) (Blob, error)
+	// Get returns a reader of the blob content. Reading fails with ErrCorruptedBlob
+	// if the content doesn't match the blob size or checksum.
+	Get(blob Blob) io.ReadCloser
+}
+
+// isBlob checks if value is sent as a blob
+func isBlob(value interface{}) bool {
+	switch value := value.(type) {
+	case io.Reader:
+		return true
+	case []byte:
+		return len(value) > blobChunkSize
+	}
+
+	return false
+}
+
+// putBlob stores value (an io.Reader or a []byte) in store
+func putBlob(store BlobStore, value interface{}) (Blob, error) {
+	if store == nil {
+		return Blob{}, fmt.Errorf("blobs are not supported")
+	}
+
+	switch value := value.(type) {
+	case io.ReadCloser:
+		defer value.Close()
+		return store.Put(value)
+	case io.Reader:
+		return store.Put(value)
+	case []byte:
+		return store.Put(bytes.NewReader(value))
+	}
+
+	return Blob{}, fmt.Errorf("invalid blob type %T", value)
+}
+
+// loadBlob loads the blob content as value of type t, t must be []byte or
+// an interface implemented by io.ReadCloser
+func loadBlob(store BlobStore, blob Blob, t reflect.Type) (reflect.Value, error) {
+	if store == nil {
+		return reflect.Value{}, fmt.Errorf("blobs are not supported")
+	}
+
+	// the blob reference comes from the peer, it must not be trusted
+	if blob.Size < 0 || blob.Chunks < 0 || blob.Size > int64(blob.Chunks)*blobChunkSize {
+		return reflect.Value{}, ErrCorruptedBlob
+	}
+
+	reader := store.Get(blob)
+	if t == bytesType {
+		defer reader.Close()
+		// the buffer grows with the chunks actually read, not with the announced size
+		var data bytes.Buffer
+		if _, err := io.Copy(&data, io.LimitReader(reader, blob.Size)); err != nil {
+			return reflect.Value{}, err
+		}
+
+		// make sure the size and checksum are verified
+		if _, err := reader.Read(nil); int64(data.Len()) != blob.Size || err != io.EOF {
+			return reflect.Value{}, ErrCorruptedBlob
+		}
+
+		return reflect.ValueOf(data.Bytes()), nil
+	}
+
+	value := reflect.ValueOf(reader)
+	if !value.Type().AssignableTo(t) {
+		reader.Close()
+		return reflect.Value{}, fmt.Errorf("can't load blob into %s", t)
+	}
+
+	return value, nil
+}
+
+// blobReader reads the chunks of a blob in order
+type blobReader struct {
+	blob  Blob
+	get   func(n int) ([]byte, error)
+	close func()
+	next  int
+	read  int64
+	chunk []byte
+	hash  hash.Hash
+}
+
+func (r *blobReader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		if r.next == r.blob.Chunks {
+			if r.read != r.blob.Size || !bytes.Equal(r.hash.Sum(nil), r.blob.Hash) {
+				return 0, ErrCorruptedBlob
+			}
+
+			return 0, io.EOF
+		}
+
+		chunk, err := r.get(r.next)
+		if err == redis.ErrNil {
+			return 0, ErrCorruptedBlob
+		} else if err != nil {
+			return 0, err
+		}
+
+		r.next++
+		r.read += int64(len(chunk))
+		r.hash.Write(chunk)
+		r.chunk = chunk
+	}
+
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+func (r *blobReader) Close() error {
+	if r.close != nil {
+		r.close()
+	}
+
+	return nil
+}
+
+// redisBlobs stores blobs in redis, each chunk in its own key
+type redisBlobs struct {
+	pool *redis.Pool
+	// ttl is the chunks time to live in seconds
+	ttl int
+	// namespace of the chunks keys
+	namespace string
+}
+
+func blobKey(id string, n int) string {
+	return fmt.Sprintf("%s.%s.%d", blobPrefix, id, n)
+}
+
+func (b *redisBlobs) key(id string, n int) string {
+	return namespaced(b.namespace, blobKey(id, n))
+}
+
+func (b *redisBlobs) Put(r io.Reader) (Blob, error) {
+	blob := Blob{ID: uuid.New().String()}
+
+	con := b.pool.Get()
+	defer con.Close()
+
+	hash := sha256.New()
+	chunk := make([]byte, blobChunkSize)
+	for {
+		n, err := io.ReadFull(r, chunk)
+		if n > 0 {
+			hash.Write(chunk[:n])
+			if _, err := con.Do("SET", b.key(blob.ID, blob.Chunks), chunk[:n], "EX", b.ttl); err != nil {
+				return blob, err
+			}
+
+			blob.Chunks++
+			blob.Size += int64(n)
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return blob, err
+		}
+	}
+
+	blob.Hash = hash.Sum(nil)
+	return blob, nil
+}
+
+// Get returns a reader of the blob. Chunks are deleted once they are read, the
+// remaining chunks are deleted once the reader is closed.
+func (b *redisBlobs) Get(blob Blob) io.ReadCloser {
+	reader := &blobReader{blob: blob, hash: sha256.New()}
+	reader.get = func(n int) ([]byte, error) {
+		con := b.pool.Get()
+		defer con.Close()
+
+		key := b.key(blob.ID, n)
+		con.Send("GET", key)
+		con.Send("DEL", key)
+		replies, err := redis.Values(con.Do(""))
+		if err != nil {
+			return nil, err
+		}
+
+		return redis.Bytes(replies[0], nil)
+	}
+
+	reader.close = func() {
+		if reader.next == blob.Chunks {
+			return
+		}
+
+		con := b.pool.Get()
+		defer con.Close()
+
+		keys := make([]interface{}, 0, blob.Chunks-reader.next)
+		for n := reader.next; n < blob.Chunks; n++ {
+			keys = append(keys, b.key(blob.ID, n))
+		}
+
+		con.Do("DEL", keys...)
+	}
+
+	return reader
+}
+
+// upload stores the blob arguments in the broker. It returns a copy of args
+// where the blob arguments are replaced with nil, and the blobs by index.
+func (c *RedisClient) upload(ctx context.Context, module string, args []interface{}) ([]interface{}, map[int]Blob, error) {
+	var blobs map[int]Blob
+	var supported *bool
+	for i, arg := range args {
+		if !isBlob(arg) {
+			continue
+		}
+
+		// io.Reader arguments are always sent as blobs, large []byte arguments
+		// only if the module servers support blobs, otherwise they are sent inline
+		if _, ok := arg.([]byte); ok {
+			if supported == nil {
+				ok := c.supports(ctx, module, FeatureBlobs)
+				supported = &ok
+			}
+
+			if !*supported {
+				continue
+			}
+		}
+
+		blob, err := putBlob(c.blobs(), arg)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if blobs == nil {
+			blobs = make(map[int]Blob)
+			args = append([]interface{}(nil), args...)
+		}
+
+		blobs[i] = blob
+		args[i] = nil
+	}
+
+	return args, blobs, nil
+}
+
+func (c *RedisClient) blobs() BlobStore {
+	return &redisBlobs{pool: c.pool, ttl: c.config.responseTTL(), namespace: c.config.namespace}
+}
@@ -0,0 +1,186 @@
+package zbus
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryBlobs is an in memory blob store
+type memoryBlobs map[string][]byte
+
+func (m memoryBlobs) Put(r io.Reader) (Blob, error) {
+	blob := Blob{ID: uuid.New().String()}
+	hash := sha256.New()
+	for {
+		chunk := make([]byte, blobChunkSize)
+		n, err := io.ReadFull(r, chunk)
+		if n > 0 {
+			hash.Write(chunk[:n])
+			m[blobKey(blob.ID, blob.Chunks)] = chunk[:n]
+			blob.Chunks++
+			blob.Size += int64(n)
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return blob, err
+		}
+	}
+
+	blob.Hash = hash.Sum(nil)
+	return blob, nil
+}
+
+func (m memoryBlobs) Get(blob Blob) io.ReadCloser {
+	return &blobReader{
+		blob: blob,
+		hash: sha256.New(),
+		get: func(n int) ([]byte, error) {
+			chunk, ok := m[blobKey(blob.ID, n)]
+			if !ok {
+				return nil, fmt.Errorf("chunk not found")
+			}
+
+			return chunk, nil
+		},
+	}
+}
+
+type files struct{}
+
+func (f *files) Size(r io.Reader) (int, error) {
+	data, err := ioutil.ReadAll(r)
+	return len(data), err
+}
+
+func (f *files) Reverse(data []byte) []byte {
+	for i, j := 0, len(data)-1; i < j; i, j = i+1, j-1 {
+		data[i], data[j] = data[j], data[i]
+	}
+
+	return data
+}
+
+func (f *files) Open(name string) (io.Reader, error) {
+	return bytes.NewBufferString(name), nil
+}
+
+func TestBlobReader(t *testing.T) {
+	store := memoryBlobs{}
+	data := bytes.Repeat([]byte("zbus"), blobChunkSize)
+
+	blob, err := store.Put(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, 4, blob.Chunks)
+	require.EqualValues(t, len(data), blob.Size)
+
+	loaded, err := ioutil.ReadAll(store.Get(blob))
+	require.NoError(t, err)
+	require.Equal(t, data, loaded)
+
+	// corrupted chunk
+	store[blobKey(blob.ID, 1)] = []byte("corrupted")
+	_, err = ioutil.ReadAll(store.Get(blob))
+	require.Equal(t, ErrCorruptedBlob, err)
+}
+
+func TestSurrogateCallBlob(t *testing.T) {
+	s := NewSurrogate(&files{})
+	store := memoryBlobs{}
+	data := bytes.Repeat([]byte("ab"), blobChunkSize)
+
+	blob, err := store.Put(bytes.NewReader(data))
+	require.NoError(t, err)
+
+	request, err := NewRequest("id", "reply-to", ObjectID{}, "Size", nil)
+	require.NoError(t, err)
+	request.Blobs = map[int]Blob{0: blob}
+
+	result, err := s.CallRequestContext(context.Background(), request, CallOptions{Blobs: store})
+	require.NoError(t, err)
+
+	var size int
+	require.NoError(t, result.Unmarshal(&Loader{&size}))
+	require.Equal(t, len(data), size)
+
+	// large returns are sent inline to callers that don't accept blobs
+	request, err = NewRequest("id", "reply-to", ObjectID{}, "Reverse", nil)
+	require.NoError(t, err)
+	request.Blobs = map[int]Blob{0: blob}
+
+	blob, err = store.Put(bytes.NewReader(data))
+	require.NoError(t, err)
+
+	result, err = s.CallRequestContext(context.Background(), request, CallOptions{Blobs: store})
+	require.NoError(t, err)
+	require.Empty(t, result.Blobs)
+
+	var reversed []byte
+	require.NoError(t, result.Unmarshal(&Loader{&reversed}))
+	require.Equal(t, bytes.Repeat([]byte("ba"), blobChunkSize), reversed)
+
+	// and as blobs to callers that accept them
+	request.Blobs = map[int]Blob{0: blob}
+	request.AcceptBlobs = true
+	result, err = s.CallRequestContext(context.Background(), request, CallOptions{Blobs: store})
+	require.NoError(t, err)
+	require.Len(t, result.Blobs, 1)
+
+	result.blobs = store
+	reversed = nil
+	require.NoError(t, result.Unmarshal(&Loader{&reversed}))
+	require.Equal(t, bytes.Repeat([]byte("ba"), blobChunkSize), reversed)
+
+	// readers are always sent as blobs
+	request, err = NewRequest("id", "reply-to", ObjectID{}, "Open", "name")
+	require.NoError(t, err)
+	request.AcceptBlobs = true
+
+	result, err = s.CallRequestContext(context.Background(), request, CallOptions{Blobs: store})
+	require.NoError(t, err)
+
+	result.blobs = store
+	var reader io.Reader
+	require.NoError(t, result.Unmarshal(&Loader{&reader}))
+	name, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, "name", string(name))
+
+	// no blob store
+	_, err = s.CallRequest(request)
+	require.Error(t, err)
+}
+
+func TestLoadBlobSize(t *testing.T) {
+	store := memoryBlobs{}
+	blob, err := store.Put(bytes.NewReader([]byte("data")))
+	require.NoError(t, err)
+
+	// a size that can't fit in the chunks is rejected before anything is allocated
+	bogus := blob
+	bogus.Size = 1 << 62
+	_, err = loadBlob(store, bogus, bytesType)
+	require.Equal(t, ErrCorruptedBlob, err)
+
+	bogus.Size = -1
+	_, err = loadBlob(store, bogus, bytesType)
+	require.Equal(t, ErrCorruptedBlob, err)
+
+	// a size that doesn't match the content
+	bogus.Size = 2
+	_, err = loadBlob(store, bogus, bytesType)
+	require.Equal(t, ErrCorruptedBlob, err)
+
+	value, err := loadBlob(store, blob, bytesType)
+	require.NoError(t, err)
+	require.Equal(t, []byte("data"), value.Interface())
+}
@@ -1,6 +1,9 @@
 package zbus
 
-import "context"
+import (
+	"context"
+	"fmt"
+)
 
 // Client defines client interface
 type Client interface {
@@ -14,3 +17,35 @@ type Client interface {
 
 	Status(ctx context.Context, module string) (Status, error)
 }
+
+// StreamClient is implemented by clients that support streaming calls
+type StreamClient interface {
+	// RequestStream makes a request to a streaming method, and returns
+	// the stream of items sent back by the server for this call
+	RequestStream(ctx context.Context, module string, object ObjectID, method string, args ...interface{}) (*CallStream, error)
+}
+
+// Registry is implemented by clients that can look up the running
+// server instances
+type Registry interface {
+	// Modules lists the names of all modules with live instances
+	Modules(ctx context.Context) ([]string, error)
+
+	// Instances lists the live instances of a module
+	Instances(ctx context.Context, module string) ([]Instance, error)
+
+	// Lookup lists the live instances of a module that serve object,
+	// returns ErrNoServer if there are none
+	Lookup(ctx context.Context, module string, object ObjectID) ([]Instance, error)
+}
+
+// RequestStream makes a request to a streaming method with client. It fails if
+// client doesn't implement StreamClient. Generated stubs use it for streaming calls.
+func RequestStream(ctx context.Context, client Client, module string, object ObjectID, method string, args ...interface{}) (*CallStream, error) {
+	c, ok := client.(StreamClient)
+	if !ok {
+		return nil, fmt.Errorf("client does not support streaming calls")
+	}
+
+	return c.RequestStream(ctx, module, object, method, args...)
+}
@@ -0,0 +1,46 @@
+package zbus
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/require"
+)
+
+// minimalClient only implements the Client interface
+type minimalClient struct {
+	Client
+}
+
+func TestOptionalInterfaces(t *testing.T) {
+	var client Client = &RedisClient{}
+	_, ok := client.(StreamClient)
+	require.True(t, ok)
+	_, ok = client.(Registry)
+	require.True(t, ok)
+
+	var server Server = &RedisServer{}
+	_, ok = server.(DynamicServer)
+	require.True(t, ok)
+	_, ok = server.(ConfigurableServer)
+	require.True(t, ok)
+}
+
+func TestRequestStreamUnsupported(t *testing.T) {
+	_, err := RequestStream(context.Background(), minimalClient{}, "module", ObjectID{Name: "utils"}, "Countdown", 3)
+	require.Error(t, err)
+}
+
+func TestRequestStreamFailFast(t *testing.T) {
+	// no instance of the module is running
+	con := &registryConn{registry: map[string]Instance{}}
+	client := &RedisClient{
+		pool:   &redis.Pool{Dial: func() (redis.Conn, error) { return con, nil }},
+		config: newConfig([]Option{WithFailFast(time.Second)}),
+	}
+
+	_, err := client.RequestStream(context.Background(), "module", ObjectID{Name: "utils"}, "Countdown", 3)
+	require.Equal(t, ErrNoServer, err)
+}
@@ -0,0 +1,186 @@
+package zbus
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack"
+)
+
+// Codec encodes and decodes the arguments, returns and events carried by zbus
+type Codec interface {
+	// ContentType is the tag of the codec in the message envelope
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	// MsgPack is the default codec
+	MsgPack Codec = msgpackCodec{}
+	// JSON codec, messages encoded with JSON can be built by tools that
+	// don't support msgpack
+	JSON Codec = jsonCodec{}
+	// CBOR codec
+	CBOR Codec = cborCodec{}
+
+	codecs = map[string]Codec{
+		MsgPack.ContentType(): MsgPack,
+		JSON.ContentType():    JSON,
+		CBOR.ContentType():    CBOR,
+	}
+	codecsM sync.RWMutex
+)
+
+// CodecClient is implemented by clients that encode requests with a codec. The
+// items of streaming calls are decoded with the same codec.
+type CodecClient interface {
+	Codec() Codec
+}
+
+// ClientCodec returns the codec of client, defaults to msgpack if the
+// client doesn't implement CodecClient. Generated stubs decode the items
+// of streaming calls and events with this codec.
+func ClientCodec(client Client) Codec {
+	if c, ok := client.(CodecClient); ok {
+		return c.Codec()
+	}
+
+	return MsgPack
+}
+
+// RegisterCodec registers a codec, so requests tagged with its content type
+// can be served. Messages encoded with a custom codec are sent in a msgpack
+// envelope, only the arguments and returns are encoded with the codec.
+func RegisterCodec(codec Codec) {
+	codecsM.Lock()
+	defer codecsM.Unlock()
+
+	codecs[codec.ContentType()] = codec
+}
+
+// GetCodec gets a registered codec by content type. An empty content type
+// is msgpack.
+func GetCodec(contentType string) (Codec, error) {
+	if len(contentType) == 0 {
+		return MsgPack, nil
+	}
+
+	codecsM.RLock()
+	defer codecsM.RUnlock()
+
+	codec, ok := codecs[contentType]
+	if !ok {
+		return nil, fmt.Errorf("unknown content type '%s'", contentType)
+	}
+
+	return codec, nil
+}
+
+// getCodec gets a codec by content type, falls back to msgpack
+// if the content type is unknown
+func getCodec(contentType string) Codec {
+	codec, err := GetCodec(contentType)
+	if err != nil {
+		return MsgPack
+	}
+
+	return codec
+}
+
+// envelope returns the codec used to encode the envelope of
+// messages that are encoded with codec
+func envelope(codec Codec) Codec {
+	switch codec {
+	case JSON, CBOR:
+		return codec
+	}
+
+	return MsgPack
+}
+
+// detect detects the codec of a message envelope. Envelopes are always
+// maps, so the codec is known from the first byte.
+func detect(data []byte) Codec {
+	data = bytes.TrimLeft(data, " \t\r\n")
+	if len(data) == 0 {
+		return MsgPack
+	}
+
+	switch {
+	case data[0] == '{':
+		return JSON
+	case data[0]>>5 == 5:
+		// cbor map major type
+		return CBOR
+	}
+
+	return MsgPack
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string {
+	return "application/msgpack"
+}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return "application/json"
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type cborCodec struct{}
+
+func (cborCodec) ContentType() string {
+	return "application/cbor"
+}
+
+func (cborCodec) Marshal(v interface{}) ([]byte, error) {
+	return cbor.Marshal(v)
+}
+
+func (cborCodec) Unmarshal(data []byte, v interface{}) error {
+	loader, ok := v.(*Loader)
+	if !ok {
+		return cbor.Unmarshal(data, v)
+	}
+
+	// cbor replaces the values of an interface slice instead of decoding
+	// into the pointers they hold, so the returns are decoded one by one
+	var elements []cbor.RawMessage
+	if err := cbor.Unmarshal(data, &elements); err != nil {
+		return err
+	}
+
+	for i, element := range elements {
+		if i >= len(*loader) {
+			break
+		}
+
+		if err := cbor.Unmarshal(element, (*loader)[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
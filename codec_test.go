@@ -0,0 +1,110 @@
+package zbus
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodecRequest(t *testing.T) {
+	for _, codec := range []Codec{MsgPack, JSON, CBOR} {
+		t.Run(codec.ContentType(), func(t *testing.T) {
+			request, err := NewRequestWithCodec(codec, "id", "reply-to", ObjectID{Name: "calc"}, "Join", " ", "hello", "world")
+			require.NoError(t, err)
+
+			data, err := request.Encode()
+			require.NoError(t, err)
+			require.Equal(t, envelope(codec), detect(data))
+
+			loaded, err := LoadRequest(data)
+			require.NoError(t, err)
+			require.Equal(t, codec.ContentType(), loaded.ContentType)
+
+			s := NewSurrogate(&T{})
+			result, err := s.CallRequest(loaded)
+			require.NoError(t, err)
+
+			response := NewResponse(loaded.ID, result, "")
+			response.ContentType = loaded.ContentType
+			data, err = response.Encode()
+			require.NoError(t, err)
+
+			response, err = LoadResponse(data)
+			require.NoError(t, err)
+
+			var joined string
+			require.NoError(t, response.Unmarshal(&Loader{&joined}))
+			require.Equal(t, "hello world", joined)
+		})
+	}
+}
+
+func TestCodecJSON(t *testing.T) {
+	// requests can be written by hand
+	request, err := LoadRequest([]byte(`{"ID": "id", "Object": {"Name": "calc"}, "Method": "Tuple", "ContentType": "application/json"}`))
+	require.NoError(t, err)
+
+	s := NewSurrogate(&T{})
+	result, err := s.CallRequest(request)
+	require.NoError(t, err)
+
+	response := NewResponse(request.ID, result, "")
+	response.ContentType = request.ContentType
+	data, err := response.Encode()
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"Data":[10,"hello","world"]`)
+
+	_, err = LoadRequest([]byte(`{"ID": "id", "ContentType": "application/unknown"}`))
+	require.Error(t, err)
+}
+
+func TestClientCodec(t *testing.T) {
+	require.Equal(t, MsgPack, ClientCodec(&RedisClient{}))
+	require.Equal(t, JSON, ClientCodec(&RedisClient{config: newConfig([]Option{WithCodec(JSON)})}))
+
+	// stream items are encoded with the request codec
+	data, err := JSON.Marshal(3)
+	require.NoError(t, err)
+
+	var n int
+	require.NoError(t, Event(data).Decode(ClientCodec(&RedisClient{config: newConfig([]Option{WithCodec(JSON)})}), &n))
+	require.Equal(t, 3, n)
+}
+
+func TestCodecTuple(t *testing.T) {
+	for _, codec := range []Codec{MsgPack, JSON, CBOR} {
+		t.Run(codec.ContentType(), func(t *testing.T) {
+			tuple, err := newTuple(codec, 10, "hello")
+			require.NoError(t, err)
+
+			var number int
+			var text string
+			require.NoError(t, tuple.Decode(codec, 0, &number))
+			require.NoError(t, tuple.Decode(codec, 1, &text))
+			require.Equal(t, 10, number)
+			require.Equal(t, "hello", text)
+			require.Error(t, tuple.Decode(codec, 2, &text))
+
+			// tuple returns are decoded with the codec of the response
+			request, err := NewRequestWithCodec(codec, "id", "reply-to", ObjectID{Name: "calc"}, "Tuple")
+			require.NoError(t, err)
+
+			result, err := NewSurrogate(&T{}).CallRequest(request)
+			require.NoError(t, err)
+
+			response := NewResponse(request.ID, result, "")
+			response.ContentType = request.ContentType
+			data, err := response.Encode()
+			require.NoError(t, err)
+
+			response, err = LoadResponse(data)
+			require.NoError(t, err)
+
+			var first, second string
+			require.NoError(t, response.Unmarshal(&Loader{&number, &first, &second}))
+			require.Equal(t, 10, number)
+			require.Equal(t, "hello", first)
+			require.Equal(t, "world", second)
+		})
+	}
+}
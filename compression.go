@@ -0,0 +1,158 @@
+package zbus
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/ioutil"
+)
+
+// CompressionGzip is the gzip compression algorithm
+const CompressionGzip = "gzip"
+
+func compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func decompress(algorithm string, data []byte) ([]byte, error) {
+	if algorithm != CompressionGzip {
+		return nil, fmt.Errorf("unknown compression '%s'", algorithm)
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+// compressible checks if messages of content type can be compressed. JSON
+// messages are not compressed since the data is inlined in the message.
+func compressible(contentType string) bool {
+	return getCodec(contentType) != JSON
+}
+
+// compress compresses the request inputs if they are larger than threshold,
+// and sets the request to accept compressed responses
+func (m *Request) compress(threshold int) error {
+	if threshold <= 0 || !compressible(m.ContentType) {
+		return nil
+	}
+
+	m.accept(threshold)
+	if m.size() <= threshold {
+		return nil
+	}
+
+	inputs := make(Tuple, 0, len(m.Inputs))
+	for _, input := range m.Inputs {
+		data, err := compress(input)
+		if err != nil {
+			return err
+		}
+
+		inputs = append(inputs, data)
+	}
+
+	m.Inputs = inputs
+	m.Compression = CompressionGzip
+	return nil
+}
+
+// accept sets the request to accept compressed responses if compression is
+// enabled. It must be called before the request is signed.
+func (m *Request) accept(threshold int) {
+	if threshold > 0 && compressible(m.ContentType) {
+		m.AcceptCompression = CompressionGzip
+	}
+}
+
+// size is the total size of the request inputs
+func (m *Request) size() int {
+	var size int
+	for _, input := range m.Inputs {
+		size += len(input)
+	}
+
+	return size
+}
+
+// compress compresses the request inputs only if all live instances of module
+// announce they support compressed requests. Older servers ignore the request
+// Compression and fail to decode compressed inputs, but they still can send
+// compressed returns.
+func (c *RedisClient) compress(ctx context.Context, module string, request *Request) error {
+	threshold := c.config.compression
+	if threshold <= 0 || request.size() <= threshold || c.supports(ctx, module, FeatureCompression) {
+		return request.compress(threshold)
+	}
+
+	request.accept(threshold)
+	return nil
+}
+
+func (m *Request) decompress() error {
+	if len(m.Compression) == 0 {
+		return nil
+	}
+
+	inputs := make(Tuple, 0, len(m.Inputs))
+	for _, input := range m.Inputs {
+		data, err := decompress(m.Compression, input)
+		if err != nil {
+			return err
+		}
+
+		inputs = append(inputs, data)
+	}
+
+	m.Inputs = inputs
+	m.Compression = ""
+	return nil
+}
+
+// compress compresses the response data if it's larger than threshold, and
+// the request accepts compressed responses
+func (m *Response) compress(request *Request, threshold int) error {
+	if threshold <= 0 || len(m.Output.Data) <= threshold ||
+		request.AcceptCompression != CompressionGzip || !compressible(m.ContentType) {
+		return nil
+	}
+
+	data, err := compress(m.Output.Data)
+	if err != nil {
+		return err
+	}
+
+	m.Output.Data = data
+	m.Output.Compression = CompressionGzip
+	return nil
+}
+
+func (t *Output) decompress() error {
+	if len(t.Compression) == 0 {
+		return nil
+	}
+
+	data, err := decompress(t.Compression, t.Data)
+	if err != nil {
+		return err
+	}
+
+	t.Data = data
+	t.Compression = ""
+	return nil
+}
@@ -0,0 +1,115 @@
+package zbus
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressRequest(t *testing.T) {
+	large := strings.Repeat("zbus", 1024)
+	request, err := NewRequest("id", "reply-to", ObjectID{Name: "calc"}, "Join", " ", large, large)
+	require.NoError(t, err)
+
+	require.NoError(t, request.compress(1024))
+	require.Equal(t, CompressionGzip, request.Compression)
+	require.Equal(t, CompressionGzip, request.AcceptCompression)
+
+	data, err := request.Encode()
+	require.NoError(t, err)
+	require.Less(t, len(data), 1024)
+
+	loaded, err := LoadRequest(data)
+	require.NoError(t, err)
+	require.Empty(t, loaded.Compression)
+
+	var value string
+	require.NoError(t, loaded.Unmarshal(1, &value))
+	require.Equal(t, large, value)
+
+	// small inputs are not compressed
+	request, err = NewRequest("id", "reply-to", ObjectID{Name: "calc"}, "Join", " ", "hello")
+	require.NoError(t, err)
+
+	require.NoError(t, request.compress(1024))
+	require.Empty(t, request.Compression)
+	require.Equal(t, CompressionGzip, request.AcceptCompression)
+
+	// json requests are never compressed
+	request, err = NewRequestWithCodec(JSON, "id", "reply-to", ObjectID{Name: "calc"}, "Join", " ", large)
+	require.NoError(t, err)
+
+	require.NoError(t, request.compress(1024))
+	require.Empty(t, request.Compression)
+	require.Empty(t, request.AcceptCompression)
+}
+
+func TestClientCompress(t *testing.T) {
+	large := strings.Repeat("zbus", 1024)
+
+	cases := []struct {
+		name       string
+		features   []string
+		compressed bool
+	}{
+		{"supported", []string{FeatureCompression}, true},
+		// older servers don't announce any features
+		{"not supported", nil, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			con := &registryConn{registry: map[string]Instance{
+				"a": {ID: "a", Seen: time.Now(), Features: c.features},
+			}}
+			client := &RedisClient{
+				pool:   &redis.Pool{Dial: func() (redis.Conn, error) { return con, nil }},
+				config: newConfig([]Option{WithCompression(1024)}),
+			}
+
+			request, err := NewRequest("id", "reply-to", ObjectID{Name: "calc"}, "Join", " ", large)
+			require.NoError(t, err)
+
+			require.NoError(t, client.compress(context.Background(), "module", request))
+			require.Equal(t, CompressionGzip, request.AcceptCompression)
+			if c.compressed {
+				require.Equal(t, CompressionGzip, request.Compression)
+			} else {
+				require.Empty(t, request.Compression)
+			}
+		})
+	}
+}
+
+func TestCompressResponse(t *testing.T) {
+	large := strings.Repeat("zbus", 1024)
+	output, err := returnFromObjects(MsgPack, nil, large)
+	require.NoError(t, err)
+
+	// the request doesn't accept compression
+	request, err := NewRequest("id", "reply-to", ObjectID{Name: "calc"}, "Join")
+	require.NoError(t, err)
+
+	response := NewResponse("id", output, "")
+	require.NoError(t, response.compress(request, 1024))
+	require.Empty(t, response.Output.Compression)
+
+	request.AcceptCompression = CompressionGzip
+	require.NoError(t, response.compress(request, 1024))
+	require.Equal(t, CompressionGzip, response.Output.Compression)
+
+	data, err := response.Encode()
+	require.NoError(t, err)
+	require.Less(t, len(data), 1024)
+
+	loaded, err := LoadResponse(data)
+	require.NoError(t, err)
+
+	var value string
+	require.NoError(t, loaded.Unmarshal(&Loader{&value}))
+	require.Equal(t, large, value)
+}
@@ -0,0 +1,75 @@
+package zbus
+
+import "sync"
+
+// maxPending is the max number of calls to a concurrency limited method that
+// are kept pending in memory. Extra calls are rejected with ErrOverloaded.
+const maxPending = 128
+
+// limiter limits the number of concurrent calls of a method. Calls
+// that exceed the limit are kept pending until a running call is done.
+type limiter struct {
+	max     uint
+	running uint
+	pending []*Request
+	m       sync.Mutex
+}
+
+func newLimiter(max uint) *limiter {
+	if max == 0 {
+		max = 1
+	}
+
+	return &limiter{max: max}
+}
+
+// acquire returns true if request can be processed now, otherwise
+// the request is queued and false is returned. It returns ErrOverloaded
+// if too many requests are already pending.
+func (l *limiter) acquire(request *Request) (bool, error) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if l.running < l.max {
+		l.running++
+		return true, nil
+	}
+
+	if len(l.pending) >= maxPending {
+		return false, ErrOverloaded
+	}
+
+	l.pending = append(l.pending, request)
+	return false, nil
+}
+
+// release must be called once an acquired request is processed. It
+// returns the next pending request (if any) that can be processed now.
+func (l *limiter) release() *Request {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if len(l.pending) == 0 {
+		l.running--
+		return nil
+	}
+
+	// we don't decrement running since the slot
+	// is given to the next pending request
+	next := l.pending[0]
+	l.pending[0] = nil
+	l.pending = l.pending[1:]
+
+	return next
+}
+
+// take removes and returns all pending requests
+func (l *limiter) take() []*Request {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	pending := l.pending
+	l.pending = nil
+
+	return pending
+}
@@ -0,0 +1,71 @@
+package zbus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiterMaxPending(t *testing.T) {
+	limiter := newLimiter(1)
+
+	request, err := NewRequest("id", "reply-to", ObjectID{Name: "calc"}, "Add", 1, 2)
+	require.NoError(t, err)
+
+	ok, err := limiter.acquire(request)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	for i := 0; i < maxPending; i++ {
+		ok, err := limiter.acquire(request)
+		require.NoError(t, err)
+		require.False(t, ok)
+	}
+
+	_, err = limiter.acquire(request)
+	require.Equal(t, ErrOverloaded, err)
+}
+
+func TestBaseServerNext(t *testing.T) {
+	s := BaseServer{}
+	s.SetLoadShedding(LoadShedding{MaxQueueWait: time.Second})
+
+	var failed []string
+	s.OnFailure(func(request *Request, reason, message string) {
+		failed = append(failed, reason)
+	})
+
+	var responses []*Response
+	cb := func(request *Request, response *Response) {
+		responses = append(responses, response)
+	}
+
+	newRequest := func(id string) *Request {
+		request, err := NewRequest(id, "reply-to", ObjectID{Name: "calc"}, "Add", 1, 2)
+		require.NoError(t, err)
+		return request
+	}
+
+	expired := newRequest("expired")
+	expired.SetExpiry(time.Now().Add(-time.Second))
+	waited := newRequest("waited")
+	waited.Time = unixMilli(time.Now().Add(-2 * time.Second))
+	valid := newRequest("valid")
+
+	limiter := newLimiter(1)
+	for _, request := range []*Request{newRequest("running"), expired, waited, valid} {
+		_, err := limiter.acquire(request)
+		require.NoError(t, err)
+	}
+	s.inflight = 4
+
+	next := s.next(limiter, cb)
+	require.Equal(t, valid, next)
+	require.EqualValues(t, 2, s.inflight)
+
+	require.Equal(t, []string{ReasonExpired}, failed)
+	require.Len(t, responses, 1)
+	require.Equal(t, "waited", responses[0].ID)
+	require.Equal(t, ErrOverloaded.Error(), *responses[0].Error)
+}
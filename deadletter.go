@@ -0,0 +1,189 @@
+package zbus
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/vmihailenco/msgpack"
+)
+
+const (
+	deadLetterPrefix = "zbus.dead"
+
+	// ReasonInvalid is the dead letter reason of payloads that could not be loaded
+	ReasonInvalid = "invalid request"
+	// ReasonExpired is the dead letter reason of requests that expired before processing
+	ReasonExpired = "expired"
+	// ReasonPanic is the dead letter reason of requests that caused a panic
+	ReasonPanic = "panic"
+	// ReasonUnauthenticated is the dead letter reason of requests with a missing or invalid signature
+	ReasonUnauthenticated = "unauthenticated"
+	// ReasonReplayed is the dead letter reason of signed requests that were
+	// already served, or are older than the replay window
+	ReasonReplayed = "replayed"
+)
+
+// DeadLetter is a request that could not be processed by the server. It's
+// stored with the raw payload so it can be inspected and requeued.
+type DeadLetter struct {
+	// Queue where the payload was received from
+	Queue string `json:"queue" yaml:"queue"`
+	// Payload is the raw request payload
+	Payload []byte `json:"payload" yaml:"payload"`
+	// Reason why the request failed
+	Reason string `json:"reason" yaml:"reason"`
+	// Message has extra details about the failure
+	Message string `json:"message,omitempty" yaml:"message,omitempty"`
+	// Time when the request has failed
+	Time time.Time `json:"time" yaml:"time"`
+}
+
+// Request loads the dead letter request payload
+func (d *DeadLetter) Request() (*Request, error) {
+	return LoadRequest(d.Payload)
+}
+
+func deadLetterKey(module string) string {
+	return fmt.Sprintf("%s.%s", deadLetterPrefix, module)
+}
+
+// deadLetter stores payload in the module dead letter queue
+// if enabled, otherwise does nothing
+func (s *RedisServer) deadLetter(queue string, payload []byte, reason, message string) {
+	if s.config.deadLetters == 0 {
+		return
+	}
+
+	data, err := msgpack.Marshal(DeadLetter{
+		Queue:   queue,
+		Payload: payload,
+		Reason:  reason,
+		Message: message,
+		Time:    time.Now(),
+	})
+
+	if err != nil {
+		s.log().Error().Err(err).Msg("failed to encode dead letter")
+		return
+	}
+
+	con := s.pool.Get()
+	defer con.Close()
+
+	key := s.config.key(deadLetterKey(s.module))
+	con.Send("MULTI")
+	con.Send("RPUSH", key, data)
+	// keep only the last n entries
+	con.Send("LTRIM", key, -s.config.deadLetters, -1)
+	if _, err := con.Do("EXEC"); err != nil {
+		s.log().Error().Err(err).Msg("failed to push dead letter")
+	}
+}
+
+// failed is called by the base server if a request processing has failed
+func (s *RedisServer) failed(request *Request, reason, message string) {
+	if s.config.deadLetters == 0 {
+		return
+	}
+
+	payload, err := request.Encode()
+	if err != nil {
+		s.log().Error().Err(err).Msg("failed to encode failed request")
+		return
+	}
+
+	s.deadLetter(s.config.key(queueName(s.module, request.Object, request.Priority.orNormal())), payload, reason, message)
+}
+
+// DeadLetters lists the dead letter queue of module
+func (c *RedisClient) DeadLetters(ctx context.Context, module string) ([]DeadLetter, error) {
+	con, err := c.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer con.Close()
+
+	values, err := redis.ByteSlices(con.Do("LRANGE", c.config.key(deadLetterKey(module)), 0, -1))
+	if err != nil {
+		return nil, err
+	}
+
+	letters := make([]DeadLetter, 0, len(values))
+	for _, data := range values {
+		var letter DeadLetter
+		if err := msgpack.Unmarshal(data, &letter); err != nil {
+			return nil, err
+		}
+
+		letters = append(letters, letter)
+	}
+
+	return letters, nil
+}
+
+// Requeue moves the oldest count requests from the dead letter queue of module
+// back to the queues they were received from. Expiry of requeued requests is
+// cleared so they don't expire again, except for signed requests which are requeued
+// as is, since changing them invalidates their signature. An expired signed request, or
+// one older than the 5 minutes replay window, must be made again by its caller. A count
+// of 0 requeues all dead letters.
+// Returns the number of requeued requests.
+func (c *RedisClient) Requeue(ctx context.Context, module string, count int) (int, error) {
+	con, err := c.pool.GetContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer con.Close()
+
+	key := c.config.key(deadLetterKey(module))
+	requeued := 0
+	for count == 0 || requeued < count {
+		data, err := redis.Bytes(con.Do("LPOP", key))
+		if err == redis.ErrNil {
+			break
+		} else if err != nil {
+			return requeued, err
+		}
+
+		var letter DeadLetter
+		if err := msgpack.Unmarshal(data, &letter); err != nil {
+			// put it back as is
+			con.Do("LPUSH", key, data)
+			return requeued, err
+		}
+
+		payload := letter.Payload
+		if request, err := letter.Request(); err == nil && len(request.Signature) != 0 {
+			// requeuing is a deliberate replay
+			con.Do("DEL", c.config.key(seenKey(request.ID)))
+		} else if err == nil && request.Expires != 0 {
+			request.Expires = 0
+			if encoded, err := request.Encode(); err == nil {
+				payload = encoded
+			}
+		}
+
+		if _, err := con.Do("RPUSH", letter.Queue, payload); err != nil {
+			con.Do("LPUSH", key, data)
+			return requeued, err
+		}
+
+		requeued++
+	}
+
+	return requeued, nil
+}
+
+// PurgeDeadLetters deletes the dead letter queue of module
+func (c *RedisClient) PurgeDeadLetters(ctx context.Context, module string) error {
+	con, err := c.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer con.Close()
+
+	_, err = con.Do("DEL", c.config.key(deadLetterKey(module)))
+	return err
+}
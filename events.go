@@ -0,0 +1,315 @@
+package zbus
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	eventsPrefix = "zbus.events"
+	// eventsBlock is how long (in milliseconds) a durable stream read blocks
+	// waiting for new events before checking the context
+	eventsBlock = 1000
+)
+
+// DurableEvent is an event read from a durable event stream
+type DurableEvent struct {
+	// ID is the event offset in the stream
+	ID string
+	// Event is the event data
+	Event Event
+
+	ack func() error
+}
+
+// Ack acknowledges the event, so it's not delivered again to the consumer
+// group. Ack does nothing for events that are not read by a consumer group.
+func (e *DurableEvent) Ack() error {
+	if e.ack == nil {
+		return nil
+	}
+
+	return e.ack()
+}
+
+func eventsKey(module string, object ObjectID, event string) string {
+	return fmt.Sprintf("%s.%s.%s.%s", eventsPrefix, module, object, event)
+}
+
+// durable appends an event to its durable stream. key is the fully
+// qualified event key `<module>.<object>.<event>` without the namespace
+func (s *RedisServer) durable(con redis.Conn, key string, data []byte) error {
+	return con.Send(
+		"XADD", s.config.key(fmt.Sprintf("%s.%s", eventsPrefix, key)),
+		"MAXLEN", "~", s.config.events,
+		"*", "data", data,
+	)
+}
+
+// entries loads the events from an XRANGE reply, or from a single stream
+// of an XREAD reply. It also returns the ids of the pending entries that
+// were trimmed (or deleted) from the stream, their data is lost.
+func entries(reply interface{}, err error) ([]DurableEvent, []string, error) {
+	values, err := redis.Values(reply, err)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var trimmed []string
+	events := make([]DurableEvent, 0, len(values))
+	for _, value := range values {
+		entry, err := redis.Values(value, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if len(entry) != 2 {
+			return nil, nil, fmt.Errorf("invalid stream entry")
+		}
+
+		id, err := redis.String(entry[0], nil)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		fields, err := redis.StringMap(entry[1], nil)
+		if err == redis.ErrNil {
+			// entry was trimmed (or deleted) while still pending
+			trimmed = append(trimmed, id)
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		events = append(events, DurableEvent{ID: id, Event: Event(fields["data"])})
+	}
+
+	return events, trimmed, nil
+}
+
+// streamEntries loads the events from an XREAD or XREADGROUP reply for a single
+// stream, and the ids of the trimmed pending entries
+func streamEntries(reply interface{}, err error) ([]DurableEvent, []string, error) {
+	streams, err := redis.Values(reply, err)
+	if err == redis.ErrNil {
+		// timed out
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	if len(streams) != 1 {
+		return nil, nil, fmt.Errorf("invalid stream reply")
+	}
+
+	stream, err := redis.Values(streams[0], nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(stream) != 2 {
+		return nil, nil, fmt.Errorf("invalid stream reply")
+	}
+
+	return entries(stream[1], nil)
+}
+
+// deliver sends events to ch, returns false if ctx was cancelled
+func deliver(ctx context.Context, ch chan<- DurableEvent, events []DurableEvent) bool {
+	for _, event := range events {
+		select {
+		case ch <- event:
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	return true
+}
+
+// Replay listens to the durable stream of event. It first delivers the last n events
+// still retained by the broker, and then new events as they are published. If the connection
+// to the broker is lost, no events are missed as long as they are not trimmed from
+// the stream. The server must be created with WithDurableEvents. The returned channel
+// is closed once ctx is cancelled.
+func (c *RedisClient) Replay(ctx context.Context, module string, object ObjectID, event string, n uint) (<-chan DurableEvent, error) {
+	key := c.config.key(eventsKey(module, object, event))
+
+	con, err := c.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// we need at least the last event to know where to continue from
+	count := n
+	if count == 0 {
+		count = 1
+	}
+
+	last, _, err := entries(con.Do("XREVRANGE", key, "+", "-", "COUNT", count))
+	con.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	// offset is where we continue reading from. if the
+	// stream is empty we read from the start
+	offset := "0-0"
+	if len(last) > 0 {
+		offset = last[0].ID
+	}
+
+	if n == 0 {
+		last = nil
+	}
+
+	// XREVRANGE returns newest first
+	for i, j := 0, len(last)-1; i < j; i, j = i+1, j-1 {
+		last[i], last[j] = last[j], last[i]
+	}
+
+	ch := make(chan DurableEvent)
+	go func() {
+		defer close(ch)
+
+		if !deliver(ctx, ch, last) {
+			return
+		}
+
+		read := func(con redis.Conn) ([]DurableEvent, error) {
+			events, _, err := streamEntries(block(con, eventsBlock*time.Millisecond, "XREAD", "BLOCK", eventsBlock, "STREAMS", key, offset))
+			return events, err
+		}
+
+		c.read(ctx, key, read, func(events []DurableEvent) bool {
+			if len(events) > 0 {
+				offset = events[len(events)-1].ID
+			}
+
+			return deliver(ctx, ch, events)
+		})
+	}()
+
+	return ch, nil
+}
+
+// Consume listens to the durable stream of event as consumer of the consumer group. Each
+// event is delivered to only one consumer of the group, and must be acknowledged with
+// DurableEvent.Ack once processed. A consumer resumes from the last acknowledged event,
+// so events that were delivered but not acknowledged (for example if the consumer crashed)
+// are delivered again. A new group starts from the oldest event retained by the broker.
+// The server must be created with WithDurableEvents. The returned channel is closed
+// once ctx is cancelled.
+func (c *RedisClient) Consume(ctx context.Context, module string, object ObjectID, event, group, consumer string) (<-chan DurableEvent, error) {
+	key := c.config.key(eventsKey(module, object, event))
+
+	con, err := c.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = con.Do("XGROUP", "CREATE", key, group, "0", "MKSTREAM")
+	con.Close()
+
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil, err
+	}
+
+	ack := func(id string) func() error {
+		return func() error {
+			con := c.pool.Get()
+			defer con.Close()
+
+			_, err := con.Do("XACK", key, group, id)
+			return err
+		}
+	}
+
+	// start with the events that were delivered to this consumer
+	// but not acknowledged, then continue with new events
+	offset := "0"
+
+	ch := make(chan DurableEvent)
+	go func() {
+		defer close(ch)
+
+		read := func(con redis.Conn) ([]DurableEvent, error) {
+			events, trimmed, err := streamEntries(block(
+				con, eventsBlock*time.Millisecond,
+				"XREADGROUP", "GROUP", group, consumer,
+				"BLOCK", eventsBlock, "STREAMS", key, offset,
+			))
+			if err != nil || len(trimmed) == 0 {
+				return events, err
+			}
+
+			// trimmed entries can't be delivered anymore, they are acknowledged
+			// so they don't stay pending (and read again) forever
+			if _, err := con.Do("XACK", redis.Args{key, group}.AddFlat(trimmed)...); err != nil {
+				return nil, err
+			}
+
+			return events, nil
+		}
+
+		c.read(ctx, key, read, func(events []DurableEvent) bool {
+			if offset != ">" && len(events) == 0 {
+				// no more pending events
+				offset = ">"
+			} else if offset != ">" {
+				offset = events[len(events)-1].ID
+			}
+
+			for i := range events {
+				events[i].ack = ack(events[i].ID)
+			}
+
+			return deliver(ctx, ch, events)
+		})
+	}()
+
+	return ch, nil
+}
+
+// read calls read in a loop until ctx is cancelled and forwards the events to
+// handle. Errors are retried, handle returns false to stop reading.
+func (c *RedisClient) read(ctx context.Context, key string, read func(redis.Conn) ([]DurableEvent, error), handle func([]DurableEvent) bool) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		events, err := c.readOnce(ctx, read)
+
+		if err != nil {
+			c.log().Error().Err(err).Str("key", key).Msg("failed to read events. Retrying in 1 second")
+			select {
+			case <-time.After(1 * time.Second):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+
+		if !handle(events) {
+			return
+		}
+	}
+}
+
+func (c *RedisClient) readOnce(ctx context.Context, read func(redis.Conn) ([]DurableEvent, error)) ([]DurableEvent, error) {
+	con, err := c.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer con.Close()
+
+	return read(con)
+}
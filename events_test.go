@@ -0,0 +1,385 @@
+package zbus
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+// recordConn records the commands sent on the connection
+type recordConn struct {
+	redis.Conn
+	commands [][]interface{}
+}
+
+func (c *recordConn) Send(cmd string, args ...interface{}) error {
+	c.commands = append(c.commands, append([]interface{}{cmd}, args...))
+	return nil
+}
+
+func (c *recordConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if len(cmd) != 0 {
+		c.Send(cmd, args...)
+	}
+
+	return nil, nil
+}
+
+func (c *recordConn) Err() error {
+	return nil
+}
+
+func (c *recordConn) Close() error {
+	return nil
+}
+
+// streamConn emulates a single redis stream read by one consumer group
+type streamConn struct {
+	redis.Conn
+
+	m         sync.Mutex
+	data      map[int][]byte
+	last      int
+	delivered int
+	pending   map[int]bool
+	acked     []string
+	// fail is the number of reads that fail as if the connection was lost
+	fail int
+}
+
+func newStreamConn() *streamConn {
+	return &streamConn{data: make(map[int][]byte), pending: make(map[int]bool)}
+}
+
+func (c *streamConn) add(data string) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.last++
+	c.data[c.last] = []byte(data)
+}
+
+// ids returns the sorted ids of set that are after offset
+func (c *streamConn) ids(set map[int]bool, offset int) []int {
+	var ids []int
+	for id := range set {
+		if id > offset {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func (c *streamConn) entries(ids []int) []interface{} {
+	var values []interface{}
+	for _, id := range ids {
+		var fields interface{}
+		if data, ok := c.data[id]; ok {
+			fields = []interface{}{[]byte("data"), data}
+		}
+		values = append(values, []interface{}{[]byte(fmt.Sprintf("%d-0", id)), fields})
+	}
+	return values
+}
+
+func (c *streamConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	offset := func(arg interface{}) int {
+		id, _ := strconv.Atoi(strings.SplitN(fmt.Sprint(arg), "-", 2)[0])
+		return id
+	}
+
+	stored := make(map[int]bool)
+	for id := range c.data {
+		stored[id] = true
+	}
+
+	var ids []int
+	switch cmd {
+	case "XGROUP":
+		return "OK", nil
+	case "XACK":
+		for _, arg := range args[2:] {
+			delete(c.pending, offset(arg))
+			c.acked = append(c.acked, fmt.Sprint(arg))
+		}
+		return int64(len(args) - 2), nil
+	case "XREVRANGE":
+		ids = c.ids(stored, 0)
+		count := offset(args[4])
+		for i, j := 0, len(ids)-1; i < j; i, j = i+1, j-1 {
+			ids[i], ids[j] = ids[j], ids[i]
+		}
+		if len(ids) > count {
+			ids = ids[:count]
+		}
+		return c.entries(ids), nil
+	case "XREAD", "XREADGROUP":
+		if c.fail > 0 {
+			c.fail--
+			return nil, io.EOF
+		}
+
+		from := args[len(args)-1]
+		switch {
+		case cmd == "XREAD":
+			ids = c.ids(stored, offset(from))
+		case from == ">":
+			ids = c.ids(stored, c.delivered)
+			for _, id := range ids {
+				c.pending[id], c.delivered = true, id
+			}
+		default:
+			ids = c.ids(c.pending, offset(from))
+		}
+
+		if len(ids) == 0 && from != "0" {
+			// nothing new before the block timeout
+			c.m.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			c.m.Lock()
+			return nil, nil
+		}
+
+		return []interface{}{[]interface{}{[]byte("stream"), c.entries(ids)}}, nil
+	}
+
+	return nil, nil
+}
+
+func (c *streamConn) DoWithTimeout(timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	return c.Do(cmd, args...)
+}
+
+func (c *streamConn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	return nil, nil
+}
+
+func (c *streamConn) Err() error {
+	return nil
+}
+
+func (c *streamConn) Close() error {
+	return nil
+}
+
+// receive reads n events from ch and returns their ids and data
+func receive(t *testing.T, ch <-chan DurableEvent, n int) (ids []string, data []string) {
+	for i := 0; i < n; i++ {
+		select {
+		case event := <-ch:
+			ids = append(ids, event.ID)
+			data = append(data, string(event.Event))
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for event %d", i)
+		}
+	}
+	return
+}
+
+func TestEventsKey(t *testing.T) {
+	key := eventsKey("module", ObjectID{Name: "object", Version: "1.0"}, "Event")
+	require.Equal(t, "zbus.events.module.object@1.0.Event", key)
+}
+
+func TestEventsNamespace(t *testing.T) {
+	con := &recordConn{}
+	server := &RedisServer{
+		module: "module",
+		pool:   &redis.Pool{Dial: func() (redis.Conn, error) { return con, nil }},
+		config: newConfig([]Option{WithNamespace("ns"), WithDurableEvents(10)}),
+	}
+
+	server.ecb("object@1.0.Event", "data")
+	require.Len(t, con.commands, 2)
+
+	// the durable stream is the one the client reads
+	client := &RedisClient{config: server.config}
+	require.Equal(t, "XADD", con.commands[0][0])
+	require.Equal(t, client.config.key(eventsKey("module", ObjectID{Name: "object", Version: "1.0"}, "Event")), con.commands[0][1])
+	require.Equal(t, "ns.zbus.events.module.object@1.0.Event", con.commands[0][1])
+
+	require.Equal(t, "PUBLISH", con.commands[1][0])
+	require.Equal(t, "ns.module.object@1.0.Event", con.commands[1][1])
+}
+
+func TestStreamEntries(t *testing.T) {
+	reply := []interface{}{
+		[]interface{}{
+			[]byte("zbus.events.module.object.Event"),
+			[]interface{}{
+				[]interface{}{[]byte("1-0"), []interface{}{[]byte("data"), []byte("a")}},
+				// trimmed entry
+				[]interface{}{[]byte("2-0"), nil},
+				[]interface{}{[]byte("3-0"), []interface{}{[]byte("data"), []byte("c")}},
+			},
+		},
+	}
+
+	events, trimmed, err := streamEntries(reply, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"2-0"}, trimmed)
+	require.Len(t, events, 2)
+	require.Equal(t, "1-0", events[0].ID)
+	require.Equal(t, Event("a"), events[0].Event)
+	require.Equal(t, "3-0", events[1].ID)
+	require.NoError(t, events[1].Ack())
+
+	// timeout
+	events, _, err = streamEntries(nil, nil)
+	require.NoError(t, err)
+	require.Empty(t, events)
+}
+
+func TestEventsAppendError(t *testing.T) {
+	con := &failConn{}
+	var logs bytes.Buffer
+	server := &RedisServer{
+		module: "module",
+		pool:   &redis.Pool{Dial: func() (redis.Conn, error) { return con, nil }},
+		config: newConfig([]Option{WithDurableEvents(10)}),
+	}
+	server.SetLogger(zerolog.New(&logs))
+
+	server.ecb("object.Event", "data")
+	require.Contains(t, logs.String(), "failed to send event")
+	require.Contains(t, logs.String(), "WRONGTYPE")
+}
+
+// failConn fails the replies of a pipeline
+type failConn struct {
+	recordConn
+}
+
+func (c *failConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if len(cmd) != 0 {
+		return c.recordConn.Do(cmd, args...)
+	}
+
+	return nil, redis.Error("WRONGTYPE Operation against a key holding the wrong kind of value")
+}
+
+func TestReplayReconnect(t *testing.T) {
+	con := newStreamConn()
+	client := &RedisClient{
+		pool:   &redis.Pool{Dial: func() (redis.Conn, error) { return con, nil }},
+		config: newConfig(nil),
+	}
+
+	con.add("a")
+	con.add("b")
+	con.add("c")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch, err := client.Replay(ctx, "module", ObjectID{Name: "object"}, "Event", 2)
+	require.NoError(t, err)
+
+	_, data := receive(t, ch, 2)
+	require.Equal(t, []string{"b", "c"}, data)
+
+	// the connection is lost, events published meanwhile are not missed
+	con.m.Lock()
+	con.fail = 1
+	con.m.Unlock()
+	con.add("d")
+	con.add("e")
+
+	ids, data := receive(t, ch, 2)
+	require.Equal(t, []string{"4-0", "5-0"}, ids)
+	require.Equal(t, []string{"d", "e"}, data)
+
+	con.add("f")
+	_, data = receive(t, ch, 1)
+	require.Equal(t, []string{"f"}, data)
+}
+
+func TestConsumeRedelivery(t *testing.T) {
+	con := newStreamConn()
+	client := &RedisClient{
+		pool:   &redis.Pool{Dial: func() (redis.Conn, error) { return con, nil }},
+		config: newConfig(nil),
+	}
+
+	con.add("a")
+	con.add("b")
+	con.add("c")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := client.Consume(ctx, "module", ObjectID{Name: "object"}, "Event", "group", "consumer")
+	require.NoError(t, err)
+
+	// only the first event is processed before the consumer stops
+	event := <-ch
+	require.Equal(t, Event("a"), event.Event)
+	require.NoError(t, event.Ack())
+	_, data := receive(t, ch, 2)
+	require.Equal(t, []string{"b", "c"}, data)
+	cancel()
+	for range ch {
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	ch, err = client.Consume(ctx, "module", ObjectID{Name: "object"}, "Event", "group", "consumer")
+	require.NoError(t, err)
+
+	// unacknowledged events are delivered again, then new events
+	con.add("d")
+	_, data = receive(t, ch, 3)
+	require.Equal(t, []string{"b", "c", "d"}, data)
+}
+
+func TestConsumeTrimmed(t *testing.T) {
+	con := newStreamConn()
+	client := &RedisClient{
+		pool:   &redis.Pool{Dial: func() (redis.Conn, error) { return con, nil }},
+		config: newConfig(nil),
+	}
+
+	con.add("a")
+	con.add("b")
+	con.add("c")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := client.Consume(ctx, "module", ObjectID{Name: "object"}, "Event", "group", "consumer")
+	require.NoError(t, err)
+	receive(t, ch, 3)
+	cancel()
+	for range ch {
+	}
+
+	// the first pending events are trimmed before the consumer comes back
+	con.m.Lock()
+	delete(con.data, 1)
+	delete(con.data, 2)
+	con.m.Unlock()
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	ch, err = client.Consume(ctx, "module", ObjectID{Name: "object"}, "Event", "group", "consumer")
+	require.NoError(t, err)
+
+	ids, _ := receive(t, ch, 1)
+	require.Equal(t, []string{"3-0"}, ids)
+
+	// trimmed entries are acknowledged, so they are not pending anymore
+	con.m.Lock()
+	defer con.m.Unlock()
+	require.Equal(t, []string{"1-0", "2-0"}, con.acked)
+	require.Equal(t, map[int]bool{3: true}, con.pending)
+}
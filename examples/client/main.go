@@ -27,6 +27,14 @@ func main() {
 	fmt.Println(utils.Tuple(ctx))
 
 	fmt.Println("after the panic")
+	countdown, err := utils.Countdown(ctx, 3)
+	if err != nil {
+		panic(err)
+	}
+	for i := range countdown {
+		fmt.Println(i)
+	}
+
 	ch, err := utils.TikTok(ctx)
 	if err != nil {
 		panic(err)
@@ -8,7 +8,8 @@ import (
 //go:generate rm -rf ../stubs
 //go:generate mkdir ../stubs
 
-//Calculator the calcuator interface
+// Calculator the calcuator interface
+//
 //go:generate zbusc -module server -version 1.0 -name calculator -package stubs github.com/threefoldtech/zbus/examples/server/api+Calculator ../stubs/calcuator_stub.go
 type Calculator interface {
 	Add(a, b float64) float64
@@ -22,7 +23,8 @@ type Calculator interface {
 type Utils interface {
 	Capitalize(s string) string
 	Tuple() (int, string, float64, error)
-	TikTok(ctx context.Context) <-chan time.Time // event
+	TikTok(ctx context.Context) <-chan time.Time        // event
+	Countdown(ctx context.Context, from int) <-chan int // streaming call
 	Panic() int
 	Sleep(t time.Duration) error
 }
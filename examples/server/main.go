@@ -82,6 +82,24 @@ func (u *Utils) TikTok(ctx context.Context) <-chan time.Time {
 	return c
 }
 
+func (u *Utils) Countdown(ctx context.Context, from int) <-chan int {
+	c := make(chan int)
+
+	go func() {
+		defer close(c)
+
+		for i := from; i >= 0; i-- {
+			select {
+			case <-ctx.Done():
+				return
+			case c <- i:
+			}
+		}
+	}()
+
+	return c
+}
+
 func (u *Utils) Sleep(t time.Duration) error {
 	fmt.Printf("sleeping for %s\n", t)
 	<-time.After(t)
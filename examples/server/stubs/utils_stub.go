@@ -39,6 +39,31 @@ func (s *UtilsStub) Capitalize(ctx context.Context, arg0 string) (ret0 string) {
 	return
 }
 
+func (s *UtilsStub) Countdown(ctx context.Context, arg1 int) (<-chan int, error) {
+	args := []interface{}{arg1}
+	recv, err := zbus.RequestStream(ctx, s.client, s.module, s.object, "Countdown", args...)
+	if err != nil {
+		return nil, err
+	}
+	codec := zbus.ClientCodec(s.client)
+	ch := make(chan int)
+	go func() {
+		defer close(ch)
+		for event := range recv.Events {
+			var obj int
+			if err := event.Decode(codec, &obj); err != nil {
+				panic(err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case ch <- obj:
+			}
+		}
+	}()
+	return ch, nil
+}
+
 func (s *UtilsStub) Panic(ctx context.Context) (ret0 int) {
 	args := []interface{}{}
 	result, err := s.client.RequestContext(ctx, s.module, s.object, "Panic", args...)
@@ -71,26 +96,12 @@ func (s *UtilsStub) Sleep(ctx context.Context, arg0 time.Duration) (ret0 error)
 }
 
 func (s *UtilsStub) TikTok(ctx context.Context) (<-chan time.Time, error) {
-	ch := make(chan time.Time)
 	recv, err := s.client.Stream(ctx, s.module, s.object, "TikTok")
 	if err != nil {
 		return nil, err
 	}
-	go func() {
-		defer close(ch)
-		for event := range recv {
-			var obj time.Time
-			if err := event.Unmarshal(&obj); err != nil {
-				panic(err)
-			}
-			select {
-			case <-ctx.Done():
-				return
-			case ch <- obj:
-			default:
-			}
-		}
-	}()
+	ch := make(chan time.Time)
+	go zbus.ForwardEvents(ctx, zbus.ClientCodec(s.client), recv, ch)
 	return ch, nil
 }
 
@@ -0,0 +1,187 @@
+package zbus
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	wakePrefix = "zbus.wake"
+	wakeTTL    = 60 // seconds
+)
+
+var (
+	feederID uint64
+)
+
+// feeder pulls requests from a set of queues and feeds them to a pool of workers.
+// the set of queues is computed before each pull, so it follows objects
+// registration. A feeder that has no more queues to serve stops, and so do its workers
+type feeder struct {
+	// wake is a queue that is always pulled with the other queues. A message pushed to
+	// it unblocks the pull so the feeder can update its queues right away
+	wake   string
+	queues func() []interface{}
+	ch     chan<- *Request
+}
+
+// queues returns the queues to pull from for objects. We have a queue per object
+// and priority. higher priority queues comes first so they are served first
+func (s *RedisServer) queues(objects ...ObjectID) []interface{} {
+	var queues []interface{}
+	for _, queue := range queueNames(s.module, objects) {
+		queues = append(queues, s.config.key(queue))
+	}
+
+	return queues
+}
+
+// shared returns the queues of all objects served by the shared workers
+func (s *RedisServer) shared() []interface{} {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	var ids []ObjectID
+	for id, obj := range s.objects {
+		if obj.config.workers == 0 {
+			ids = append(ids, id)
+		}
+	}
+
+	// shared pool always runs even with no objects
+	return append([]interface{}{}, s.queues(ids...)...)
+}
+
+// startPool starts a pool of workers and its feeder. Must be called
+// with the state lock held while the server is running
+func (s *RedisServer) startPool(workers uint, queues func() []interface{}) {
+	f := &feeder{
+		wake:   s.config.key(fmt.Sprintf("%s.%s.%d", wakePrefix, s.instance.ID, atomic.AddUint64(&feederID, 1))),
+		queues: queues,
+		ch:     s.Start(s.workerCtx, &s.workersWg, workers, s.cb),
+	}
+
+	s.feedersM.Lock()
+	if s.feeders == nil {
+		s.feeders = make(map[*feeder]struct{})
+	}
+	s.feeders[f] = struct{}{}
+	s.feedersM.Unlock()
+
+	s.feedersWg.Add(1)
+	go func() {
+		defer s.feedersWg.Done()
+		s.serve(s.ctx, f)
+
+		s.feedersM.Lock()
+		delete(s.feeders, f)
+		s.feedersM.Unlock()
+	}()
+}
+
+// startDedicated starts the dedicated pool of object id if
+// it's configured with dedicated workers.
+func (s *RedisServer) startDedicated(id ObjectID) {
+	s.m.RLock()
+	obj, ok := s.objects[id]
+	s.m.RUnlock()
+
+	if !ok || obj.config.workers == 0 {
+		return
+	}
+
+	s.startPool(obj.config.workers, func() []interface{} {
+		s.m.RLock()
+		defer s.m.RUnlock()
+		// the pool is only valid as long as this exact object is registered
+		if s.objects[id] != obj {
+			return nil
+		}
+
+		return s.queues(id)
+	})
+}
+
+// wake unblocks all feeders so they can update their queues
+func (s *RedisServer) wake() {
+	s.feedersM.Lock()
+	defer s.feedersM.Unlock()
+
+	if len(s.feeders) == 0 {
+		return
+	}
+
+	con := s.pool.Get()
+	defer con.Close()
+
+	for f := range s.feeders {
+		sendWake(con, f.wake)
+	}
+
+	if _, err := con.Do(""); err != nil {
+		s.log().Error().Err(err).Msg("failed to wake feeders")
+	}
+}
+
+// sendWake queues a message to the wake queue key, the pull
+// of this queue returns right away
+func sendWake(con redis.Conn, key string) {
+	con.Send("RPUSH", key, 1)
+	con.Send("EXPIRE", key, wakeTTL)
+}
+
+// serve pulls requests from the feeder queues and feeds them to the feeder workers
+// until the context is cancelled or the feeder has no more queues to serve
+func (s *RedisServer) serve(ctx context.Context, f *feeder) {
+	// we are the only sender on this channel, closing
+	// it stops the workers once they are free
+	defer close(f.ch)
+
+	for {
+		// wait for free worker before we poll for jobs
+		select {
+		case f.ch <- &NoOP:
+		case <-ctx.Done():
+			return
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		queues := f.queues()
+		if queues == nil {
+			return
+		}
+
+		pullArgs := append(queues, f.wake, s.config.pullTimeout())
+		queue, payload, err := s.getNext(pullArgs)
+
+		if err == redis.ErrNil || queue == f.wake {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+			continue
+		} else if err != nil {
+			s.log().Error().Err(err).Msg("failed to get next job. Retrying in 1 second")
+			<-time.After(1 * time.Second)
+			continue
+		}
+
+		request := s.load(queue, payload)
+		if request == nil {
+			continue
+		}
+
+		// force wait for a worker to poll
+		// the job (since we sure there is one free)
+		// we don't allow shutting the workers down here.
+		f.ch <- request
+	}
+}
@@ -100,6 +100,8 @@ func generateStub(opt Options, typ reflect.Type) *jen.File {
 		method := typ.Method(i)
 		if isStream(&method) {
 			generateStream(f, stub, &method)
+		} else if isStreamCall(&method) {
+			generateStreamCall(f, stub, &method)
 		} else {
 			generateFunc(f, stub, &method)
 		}
@@ -129,13 +131,58 @@ func isStream(method *reflect.Method) bool {
 	return true
 }
 
+// isStreamCall checks if method is a streaming call of the form
+// `fn(Context, args...) -> chan T`
+func isStreamCall(method *reflect.Method) bool {
+	typ := method.Type
+	if typ.NumIn() < 2 || typ.NumOut() != 1 {
+		return false
+	}
+	if !typ.In(0).Implements(contextType) {
+		return false
+	}
+	if typ.Out(0).Kind() != reflect.Chan {
+		return false
+	}
+
+	return true
+}
+
+func generateStreamCall(f *jen.File, name string, method *reflect.Method) {
+	elem := method.Type.Out(0).Elem()
+	f.Func().Parens(jen.Id("s").Op("*").Id(name)).Id(method.Name).
+		Params(getMethodParams(method)...).
+		Params(
+			getTypeCode(jen.Op("<-").Id("chan"), elem),
+			jen.Id("error"),
+		).BlockFunc(getStreamCallBody(method))
+}
+
+func getStreamCallBody(method *reflect.Method) func(*jen.Group) {
+	typ := method.Type
+	return func(g *jen.Group) {
+		for _, code := range getArguments(method) {
+			g.Add(code)
+		}
+
+		g.List(jen.Id("recv"), jen.Id("err")).Op(":=").Qual("github.com/threefoldtech/zbus", "RequestStream").
+			Call(jen.Id("ctx"), jen.Id("s").Dot("client"), jen.Id("s").Dot("module"), jen.Id("s").Dot("object"), jen.Lit(method.Name), jen.Id("args").Op("..."))
+
+		g.If(jen.Id("err").Op("!=").Nil()).Block(
+			jen.Return(jen.List(jen.Nil(), jen.Id("err"))),
+		)
+
+		getStreamForward(g, typ.Out(0).Elem(), jen.Id("recv").Dot("Events"))
+	}
+}
+
 func generateStream(f *jen.File, name string, method *reflect.Method) {
 	out := method.Type.Out(0)
 	elem := out.Elem()
 	f.Func().Parens(jen.Id("s").Op("*").Id(name)).Id(method.Name).
 		Params(jen.Id("ctx").Qual("context", "Context")).
 		Params(
-			jen.Op("<-").Id("chan").Qual(elem.PkgPath(), elem.Name()),
+			getTypeCode(jen.Op("<-").Id("chan"), elem),
 			jen.Id("error"),
 		).BlockFunc(getStreamBody(method))
 }
@@ -143,8 +190,6 @@ func generateStream(f *jen.File, name string, method *reflect.Method) {
 func getStreamBody(method *reflect.Method) func(*jen.Group) {
 	elem := method.Type.Out(0).Elem()
 	return func(g *jen.Group) {
-		g.Id("ch").Op(":=").Make(jen.Id("chan").Qual(elem.PkgPath(), elem.Name()), jen.Lit(1))
-
 		g.List(jen.Id("recv"), jen.Id("err")).Op(":=").Id("s").Dot("client").Dot("Stream").
 			Call(jen.Id("ctx"), jen.Id("s").Dot("module"), jen.Id("s").Dot("object"), jen.Lit(method.Name))
 
@@ -152,27 +197,44 @@ func getStreamBody(method *reflect.Method) func(*jen.Group) {
 			jen.Return(jen.List(jen.Nil(), jen.Id("err"))),
 		)
 
-		g.Go().Func().Params().Block(
-			jen.Defer().Close(jen.Id("ch")),
-			jen.For(jen.Id("event").Op(":=").Range().Id("recv")).Block(
-				jen.Var().Id("obj").Qual(elem.PkgPath(), elem.Name()),
+		// the back pressure policy is applied by the last hop, so only
+		// values the caller can receive are kept
+		g.Id("ch").Op(":=").Make(getTypeCode(jen.Id("chan"), elem))
+		g.Go().Qual("github.com/threefoldtech/zbus", "ForwardEvents").Call(
+			jen.Id("ctx"),
+			jen.Qual("github.com/threefoldtech/zbus", "ClientCodec").Call(jen.Id("s").Dot("client")),
+			jen.Id("recv"),
+			jen.Id("ch"),
+		)
+		g.Return(jen.Id("ch"), jen.Nil())
+	}
+}
 
-				jen.If(
-					jen.Id("err").Op(":=").Id("event").Dot("Unmarshal").Call(jen.Op("&").Id("obj")).
-						Op(";").Id("err").Op("!=").Nil()).Block(
-					jen.Panic(jen.Id("err")),
-				),
+// getStreamForward generates the code that decodes the events received from
+// source with the client codec and forwards them to the returned channel
+func getStreamForward(g *jen.Group, elem reflect.Type, source jen.Code) {
+	g.Id("codec").Op(":=").Qual("github.com/threefoldtech/zbus", "ClientCodec").Call(jen.Id("s").Dot("client"))
+	g.Id("ch").Op(":=").Make(getTypeCode(jen.Id("chan"), elem))
 
-				jen.Select().Block(
-					jen.Case(jen.Op("<-").Id("ctx").Dot("Done").Call()).Block(jen.Return()),
-					jen.Case(jen.Id("ch").Op("<-").Id("obj")).Block(),
-					jen.Default().Block(),
-				),
+	g.Go().Func().Params().Block(
+		jen.Defer().Close(jen.Id("ch")),
+		jen.For(jen.Id("event").Op(":=").Range().Add(source)).Block(
+			getTypeCode(jen.Var().Id("obj"), elem),
+
+			jen.If(
+				jen.Id("err").Op(":=").Id("event").Dot("Decode").Call(jen.Id("codec"), jen.Op("&").Id("obj")).
+					Op(";").Id("err").Op("!=").Nil()).Block(
+				jen.Panic(jen.Id("err")),
 			),
-		).Call()
 
-		g.Return(jen.Id("ch"), jen.Nil())
-	}
+			jen.Select().Block(
+				jen.Case(jen.Op("<-").Id("ctx").Dot("Done").Call()).Block(jen.Return()),
+				jen.Case(jen.Id("ch").Op("<-").Id("obj")).Block(),
+			),
+		),
+	).Call()
+
+	g.Return(jen.Id("ch"), jen.Nil())
 }
 
 func generateFunc(f *jen.File, name string, method *reflect.Method) {
@@ -183,12 +245,24 @@ func generateFunc(f *jen.File, name string, method *reflect.Method) {
 	)
 }
 
-func getMethodBody(m *reflect.Method) []jen.Code {
+// firstArgument returns the index of the first method argument that is sent
+// to the server. A context.Context first argument is not sent.
+func firstArgument(m *reflect.Method) int {
+	typ := m.Type
+	if typ.NumIn() > 0 && typ.In(0).Implements(contextType) {
+		return 1
+	}
+
+	return 0
+}
+
+// getArguments generates the code that builds the args list of the call
+func getArguments(m *reflect.Method) []jen.Code {
 	typ := m.Type
 
 	var names []jen.Code
 
-	for i := 0; i < typ.NumIn(); i++ {
+	for i := firstArgument(m); i < typ.NumIn(); i++ {
 		stmt := jen.Id(fmt.Sprintf("%s%d", ArgumentPrefix, i))
 		if typ.IsVariadic() && i == typ.NumIn()-1 {
 			break
@@ -219,6 +293,13 @@ func getMethodBody(m *reflect.Method) []jen.Code {
 		)
 	}
 
+	return code
+}
+
+func getMethodBody(m *reflect.Method) []jen.Code {
+	typ := m.Type
+	code := getArguments(m)
+
 	inputs := []jen.Code{
 		jen.Id("ctx"),
 		jen.Id("s").Dot("module"),
@@ -303,6 +384,12 @@ func getTypeCode(s *jen.Statement, t reflect.Type) *jen.Statement {
 			s.Op("[]"),
 			t.Elem(),
 		)
+	case reflect.Chan:
+		// input channels are always received by the stub
+		return getTypeCode(
+			s.Op("<-").Id("chan"),
+			t.Elem(),
+		)
 	// case reflect.Interface:
 	// 	if t.Name() == "error" {
 	// 		return s.Op("*").Qual("github.com/threefoldtech/zbus", "RemoteError")
@@ -324,7 +411,7 @@ func getMethodParams(m *reflect.Method) []jen.Code {
 	}
 
 	typ := m.Type
-	for i := 0; i < typ.NumIn(); i++ {
+	for i := firstArgument(m); i < typ.NumIn(); i++ {
 		argName := fmt.Sprintf("%s%d", ArgumentPrefix, i)
 		argType := typ.In(i)
 		stmt := jen.Id(argName)
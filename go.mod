@@ -5,6 +5,7 @@ go 1.12
 require (
 	github.com/dave/jennifer v1.3.0
 	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/fxamacker/cbor/v2 v2.4.0
 	github.com/gomodule/redigo v1.8.9
 	github.com/google/uuid v1.1.1
 	github.com/kr/pretty v0.1.0 // indirect
@@ -0,0 +1,35 @@
+package zbus
+
+import (
+	"fmt"
+	"strings"
+)
+
+// namespaced returns key in namespace. Keys are not
+// prefixed if namespace is empty
+func namespaced(namespace, key string) string {
+	if len(namespace) == 0 {
+		return key
+	}
+
+	return fmt.Sprintf("%s.%s", namespace, key)
+}
+
+// key returns the broker key of name in the configured namespace
+func (c *config) key(name string) string {
+	return namespaced(c.namespace, name)
+}
+
+// pattern returns the glob style pattern p in the configured namespace
+func (c *config) pattern(p string) string {
+	return namespaced(escapePattern(c.namespace), p)
+}
+
+// name returns the name of a broker key, without the configured namespace
+func (c *config) name(key string) string {
+	if len(c.namespace) == 0 {
+		return key
+	}
+
+	return strings.TrimPrefix(key, c.namespace+".")
+}
@@ -0,0 +1,19 @@
+package zbus
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNamespace(t *testing.T) {
+	cfg := newConfig(nil)
+	require.Equal(t, "module.calc", cfg.key("module.calc"))
+	require.Equal(t, "module.*", cfg.pattern("module.*"))
+	require.Equal(t, "module.calc", cfg.name("module.calc"))
+
+	cfg = newConfig([]Option{WithNamespace("test*")})
+	require.Equal(t, "test*.module.calc", cfg.key("module.calc"))
+	require.Equal(t, `test\*.module.*`, cfg.pattern("module.*"))
+	require.Equal(t, "module.calc", cfg.name("test*.module.calc"))
+}
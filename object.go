@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"reflect"
 	"strings"
+
+	"github.com/rs/zerolog"
+	log "github.com/rs/zerolog/log"
 )
 
 var (
@@ -115,34 +118,119 @@ func (s *Surrogate) Call(name string, args ...interface{}) (ret Output, err erro
 
 	results := method.Call(values)
 
-	return returnFromValues(results)
+	return returnFromValues(results, MsgPack, nil)
+
+}
 
+// InputSource returns the raw (encoded with the request codec) items of the input channel of a call. The
+// channel is closed at the end of the input stream. It's only called if the method takes
+// an input channel.
+type InputSource func() <-chan []byte
+
+// CallOptions are the transport facilities available to a call
+type CallOptions struct {
+	// Input is the source of the input channel of the call
+	Input InputSource
+	// Blobs stores the blob arguments and returns of the call
+	Blobs BlobStore
+	// Logger reports the errors of the call, nil means the global logger
+	Logger *zerolog.Logger
+}
+
+// log returns the logger of the call
+func (o *CallOptions) log() *zerolog.Logger {
+	if o.Logger == nil {
+		return &log.Logger
+	}
+
+	return o.Logger
 }
 
 // CallRequest calls a method defined by request
 func (s *Surrogate) CallRequest(request *Request) (ret Output, err error) {
+	return s.CallRequestContext(context.Background(), request, CallOptions{})
+}
+
+// CallRequestContext calls a method defined by request. If the method takes a context.Context
+// as first argument it's called with ctx. If the method takes an input channel argument
+// the channel receives the items of opts.Input. Blob arguments are read from opts.Blobs,
+// and large returns are stored there if the request accepts blobs.
+func (s *Surrogate) CallRequestContext(ctx context.Context, request *Request, opts CallOptions) (ret Output, err error) {
 	method, err := s.getMethod(request.Method)
 	if err != nil {
 		return ret, err
 	}
 
-	methodType := method.Type()
-	if err := s.isValid(methodType, request.NumArguments()); err != nil {
+	values, err := s.arguments(ctx, method.Type(), request, opts)
+	if err != nil {
 		return ret, err
 	}
 
-	expected := methodType.NumIn()
+	results := method.Call(values)
+
+	// older callers don't know about blobs, large returns are sent inline
+	var blobs BlobStore
+	if request.AcceptBlobs {
+		blobs = opts.Blobs
+	}
+
+	return returnFromValues(results, request.codec(), blobs)
+}
 
+// isInput checks if an argument of type t is an input channel
+func isInput(t reflect.Type) bool {
+	return t.Kind() == reflect.Chan && t.ChanDir()&reflect.RecvDir != 0
+}
+
+// arguments loads the request arguments for a method of type methodType. If the method
+// takes a context.Context as first argument, it's set to ctx. An input channel argument
+// is fed from opts.Input, and blob arguments are loaded from opts.Blobs.
+func (s *Surrogate) arguments(ctx context.Context, methodType reflect.Type, request *Request, opts CallOptions) ([]reflect.Value, error) {
+	expected := methodType.NumIn()
 	if methodType.IsVariadic() {
 		expected--
 	}
 
-	values := make([]reflect.Value, 0, len(request.Inputs))
-	for i := 0; i < expected; i++ {
+	values := make([]reflect.Value, 0, len(request.Inputs)+1)
+
+	// skip is the number of arguments not provided by the request
+	var skip int
+	if expected > 0 && methodType.In(0).Implements(contextType) {
+		values = append(values, reflect.ValueOf(ctx))
+		skip = 1
+	}
+
+	if args := request.NumArguments(); args < expected-skip || !methodType.IsVariadic() && args > expected-skip {
+		return nil, fmt.Errorf("invalid number of arguments expecting %d got %d", expected-skip, args)
+	}
+
+	inputs := 0
+	for i := skip; i < expected; i++ {
 		expect := methodType.In(i)
-		value, err := request.Argument(i, expect)
+		if isInput(expect) {
+			if inputs++; inputs > 1 {
+				return nil, fmt.Errorf("methods with multiple input channels are not supported")
+			} else if opts.Input == nil {
+				return nil, fmt.Errorf("input channels are not supported")
+			}
+
+			values = append(values, feed(ctx, opts.log(), request.codec(), expect.Elem(), opts.Input()))
+			continue
+		}
+
+		if blob, ok := request.Blobs[i-skip]; ok {
+			value, err := loadBlob(opts.Blobs, blob, expect)
+			if err != nil {
+				return nil, fmt.Errorf("invalid blob argument [%d]: %s", i-skip, err)
+			}
+
+			values = append(values, value)
+			continue
+		}
+
+		value, err := request.Argument(i-skip, expect)
 		if err != nil {
-			return ret, fmt.Errorf("invalid argument type [%d] expecting %s got", i, expect)
+			return nil, fmt.Errorf("invalid argument type [%d] expecting %s got", i-skip, expect)
 		}
 
 		values = append(values, value)
@@ -150,21 +238,74 @@ func (s *Surrogate) CallRequest(request *Request) (ret Output, err error) {
 
 	if methodType.IsVariadic() {
 		expect := methodType.In(methodType.NumIn() - 1).Elem()
-		for i := expected; i < request.NumArguments(); i++ {
+		for i := expected - skip; i < request.NumArguments(); i++ {
 			value, err := request.Argument(i, expect)
 			if err != nil {
-				return ret, fmt.Errorf("invalid argument type [%d] expecting %s", i+expected, expect)
+				return nil, fmt.Errorf("invalid argument type [%d] expecting %s", i+expected, expect)
 			}
 
 			values = append(values, value)
 		}
+	}
+
+	return values, nil
+}
+
+// feed returns a channel of elem that receives the items of input decoded with codec.
+// The channel is closed once input is closed or ctx is cancelled. Items that can't be
+// decoded are reported to logger and skipped.
+func feed(ctx context.Context, logger *zerolog.Logger, codec Codec, elem reflect.Type, input <-chan []byte) reflect.Value {
+	ch := reflect.MakeChan(reflect.ChanOf(reflect.BothDir, elem), 0)
+	done := reflect.ValueOf(ctx.Done())
+
+	go func() {
+		defer ch.Close()
+
+		for data := range input {
+			value := reflect.New(elem)
+			if err := codec.Unmarshal(data, value.Interface()); err != nil {
+				logger.Error().Err(err).Msgf("failed to decode input of type %s", elem)
+				continue
+			}
+
+			chosen, _, _ := reflect.Select([]reflect.SelectCase{
+				{Dir: reflect.SelectSend, Chan: ch, Send: value.Elem()},
+				{Dir: reflect.SelectRecv, Chan: done},
+			})
+
+			if chosen == 1 {
+				return
+			}
+		}
+	}()
 
+	return ch
+}
+
+// CallStream calls a streaming method defined by request. Streaming methods take
+// a context.Context as first argument, and return a single chan of values. The
+// returned channel is closed once the method closes its channel or ctx is cancelled.
+// If the method takes an input channel argument the channel receives the items of opts.Input.
+func (s *Surrogate) CallStream(ctx context.Context, request *Request, opts CallOptions) (<-chan interface{}, error) {
+	method, err := s.getMethod(request.Method)
+	if err != nil {
+		return nil, err
 	}
 
-	results := method.Call(values)
+	methodType := method.Type()
+	if methodType.NumIn() == 0 || !methodType.In(0).Implements(contextType) ||
+		methodType.NumOut() != 1 || methodType.Out(0).Kind() != reflect.Chan {
+		return nil, fmt.Errorf("not a streaming method")
+	}
 
-	return returnFromValues(results)
+	values, err := s.arguments(ctx, methodType, request, opts)
+	if err != nil {
+		return nil, err
+	}
 
+	results := method.Call(values)
+
+	return forward(ctx, results[0]), nil
 }
 
 // Streams return all stream objects associated with this object
@@ -212,8 +353,6 @@ func (s *Stream) Name() string {
 
 // Run stream to completion
 func (s *Stream) Run(ctx context.Context) <-chan interface{} {
-	out := make(chan interface{})
-
 	in := []reflect.Value{
 		reflect.ValueOf(ctx),
 	}
@@ -222,7 +361,14 @@ func (s *Stream) Run(ctx context.Context) <-chan interface{} {
 	//and number of outputs in the "Streams" method, so
 	//it's safe to access the values with index directly
 	values := s.method.Call(in)
-	ch := values[0]
+	return forward(ctx, values[0])
+}
+
+// forward the values received from ch (a reflected chan) to the returned
+// channel until ch is closed or ctx is cancelled
+func forward(ctx context.Context, ch reflect.Value) <-chan interface{} {
+	out := make(chan interface{})
+
 	go func() {
 		defer close(out)
 
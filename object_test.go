@@ -1,14 +1,17 @@
 package zbus
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack"
 )
 
 type T struct {
@@ -39,10 +42,40 @@ func (t *T) TupleError() (int, string, error) {
 	return 10, "test", fmt.Errorf("some error")
 }
 
+func (t *T) Panic() int {
+	panic("we paniced")
+}
+
 func (t *T) Tuple() (int, string, string) {
 	return 10, "hello", "world"
 }
 
+func (t *T) Count(ctx context.Context, n int) <-chan int {
+	c := make(chan int)
+
+	go func() {
+		defer close(c)
+		for i := 1; i <= n; i++ {
+			select {
+			case <-ctx.Done():
+				return
+			case c <- i:
+			}
+		}
+	}()
+
+	return c
+}
+
+func (t *T) Sum(ctx context.Context, in <-chan int) int {
+	var sum int
+	for value := range in {
+		sum += value
+	}
+
+	return sum
+}
+
 func (t *T) TikTok(ctx context.Context) <-chan int {
 	c := make(chan int)
 
@@ -338,3 +371,83 @@ func TestStreamRun(t *testing.T) {
 		t.Fatal()
 	}
 }
+
+func TestSurrogateCallStream(t *testing.T) {
+	s := NewSurrogate(&T{"my-name"})
+
+	request, err := NewRequest("id", "reply-to", ObjectID{}, "Count", 3)
+	require.NoError(t, err)
+
+	items, err := s.CallStream(context.Background(), request, CallOptions{})
+	require.NoError(t, err)
+
+	var values []interface{}
+	for item := range items {
+		values = append(values, item)
+	}
+
+	require.Equal(t, []interface{}{1, 2, 3}, values)
+
+	request, err = NewRequest("id", "reply-to", ObjectID{}, "Add", 1, 2)
+	require.NoError(t, err)
+
+	_, err = s.CallStream(context.Background(), request, CallOptions{})
+	require.Error(t, err)
+
+	request, err = NewRequest("id", "reply-to", ObjectID{}, "Count")
+	require.NoError(t, err)
+
+	_, err = s.CallStream(context.Background(), request, CallOptions{})
+	require.Error(t, err)
+}
+
+func TestSurrogateCallInput(t *testing.T) {
+	s := NewSurrogate(&T{"my-name"})
+
+	// the input channel is sent as a placeholder
+	request, err := NewRequest("id", "reply-to", ObjectID{}, "Sum", nil)
+	require.NoError(t, err)
+
+	input := func() <-chan []byte {
+		ch := make(chan []byte, 3)
+		for i := 1; i <= 3; i++ {
+			data, err := msgpack.Marshal(i)
+			require.NoError(t, err)
+			ch <- data
+		}
+		close(ch)
+
+		return ch
+	}
+
+	result, err := s.CallRequestContext(context.Background(), request, CallOptions{Input: input})
+	require.NoError(t, err)
+
+	var sum int
+	require.NoError(t, result.Unmarshal(&Loader{&sum}))
+	require.Equal(t, 6, sum)
+
+	// no input source
+	_, err = s.CallRequest(request)
+	require.Error(t, err)
+
+	// invalid items are skipped and reported to the call logger
+	invalid := func() <-chan []byte {
+		ch := make(chan []byte, 2)
+		data, err := msgpack.Marshal(5)
+		require.NoError(t, err)
+		ch <- []byte("\xc1")
+		ch <- data
+		close(ch)
+
+		return ch
+	}
+
+	var logs bytes.Buffer
+	logger := zerolog.New(&logs)
+	result, err = s.CallRequestContext(context.Background(), request, CallOptions{Input: invalid, Logger: &logger})
+	require.NoError(t, err)
+	require.NoError(t, result.Unmarshal(&Loader{&sum}))
+	require.Equal(t, 5, sum)
+	require.Contains(t, logs.String(), "failed to decode input of type int")
+}
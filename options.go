@@ -0,0 +1,258 @@
+package zbus
+
+import (
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/rs/zerolog"
+	log "github.com/rs/zerolog/log"
+)
+
+// Option configures a redis server or client. Some options only make
+// sense for one side, in that case they are ignored by the other side.
+type Option func(*config)
+
+type config struct {
+	// failFast is the interval used by the client to check
+	// that the called object is still served, 0 means disabled
+	failFast time.Duration
+	// ttl is the default request time to live
+	ttl time.Duration
+	// deadLetters is the max size of the dead letter queue, 0 means disabled
+	deadLetters int
+	// shedding is the server load shedding policy
+	shedding LoadShedding
+	// drain is how long to wait for in-flight calls on shutdown
+	drain time.Duration
+	// events is the max length of durable event streams, 0 means disabled
+	events uint
+	// codec is the codec of requests (client) or events (server)
+	codec Codec
+	// compression is the size above which payloads are compressed, 0 means disabled
+	compression int
+	// keyring signs and verifies messages, nil means messages are not signed
+	keyring *Keyring
+	// pool configures the broker connection pool
+	pool PoolConfig
+	// dial are extra options used to dial the broker
+	dial []redis.DialOption
+	// pull is how long the server waits for requests in a single pull
+	pull time.Duration
+	// reply is how long responses, streams and blobs are kept in the broker
+	reply time.Duration
+	// logger is the logger, nil means the global logger
+	logger *zerolog.Logger
+	// namespace prefixes all broker keys, empty means no prefix
+	namespace string
+}
+
+// PoolConfig configures the pool of connections to the broker. Zero
+// values keep the defaults.
+type PoolConfig struct {
+	// MaxActive is the max number of open connections. Default is 100
+	MaxActive int
+	// MaxIdle is the max number of idle connections that are kept open.
+	// Default is 0, connections are closed once they are released
+	MaxIdle int
+	// IdleTimeout closes the connections that are idle for longer. Default is 1 minute
+	IdleTimeout time.Duration
+	// TestOnBorrow checks the connections that are idle for longer with a
+	// PING before they are used. Default is 10 seconds
+	TestOnBorrow time.Duration
+}
+
+func newConfig(opts []Option) config {
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return cfg
+}
+
+// pullTimeout returns the pull timeout in seconds
+func (c *config) pullTimeout() int {
+	if c.pull <= 0 {
+		return redisPullTimeout
+	}
+
+	// blocking commands timeout has a resolution of a second
+	return int((c.pull + time.Second - 1) / time.Second)
+}
+
+// responseTTL returns the time to live of responses in seconds
+func (c *config) responseTTL() int {
+	if c.reply <= 0 {
+		return redisResponseTTL
+	}
+
+	return int((c.reply + time.Second - 1) / time.Second)
+}
+
+// log returns the configured logger
+func (c *config) log() *zerolog.Logger {
+	if c.logger == nil {
+		return &log.Logger
+	}
+
+	return c.logger
+}
+
+// encoding returns the configured codec, defaults to msgpack
+func (c *config) encoding() Codec {
+	if c.codec == nil {
+		return MsgPack
+	}
+
+	return c.codec
+}
+
+// WithFailFast (client only) makes the client check the registry before
+// a request is sent, and then every interval while waiting for the response.
+// If no live server instance is serving the object the request fails
+// with ErrNoServer instead of waiting for the context to be cancelled. If
+// the registry can't be checked, the request waits for its response.
+func WithFailFast(interval time.Duration) Option {
+	return func(cfg *config) {
+		cfg.failFast = interval
+	}
+}
+
+// WithRequestTTL (client only) sets a time to live on all requests. A request
+// that is not picked by the server before it expires is discarded. Requests
+// made with a context that has a deadline always expire on that deadline.
+func WithRequestTTL(ttl time.Duration) Option {
+	return func(cfg *config) {
+		cfg.ttl = ttl
+	}
+}
+
+// WithDeadLetterQueue (server only) enables the module dead letter queue. Requests
+// that can't be loaded, that expired, or that caused a panic are stored in the
+// queue with the failure reason. Only the last size entries are kept.
+func WithDeadLetterQueue(size int) Option {
+	return func(cfg *config) {
+		cfg.deadLetters = size
+	}
+}
+
+// WithLoadShedding (server only) sets the server load shedding policy. Requests
+// are rejected with ErrOverloaded once the policy thresholds are exceeded.
+func WithLoadShedding(policy LoadShedding) Option {
+	return func(cfg *config) {
+		cfg.shedding = policy
+	}
+}
+
+// WithDrainTimeout (server only) sets how long the server waits for in-flight
+// calls and streams to finish once it's asked to stop. Default is 30 seconds.
+func WithDrainTimeout(timeout time.Duration) Option {
+	return func(cfg *config) {
+		cfg.drain = timeout
+	}
+}
+
+// WithDurableEvents (server only) makes the server append all published events
+// to durable streams, in addition to publishing them to live subscribers. Clients
+// can then replay events or consume them with acknowledgement. Each event stream
+// keeps approximately the last size events.
+func WithDurableEvents(size uint) Option {
+	return func(cfg *config) {
+		cfg.events = size
+	}
+}
+
+// WithCodec sets the codec used by the client to encode requests, the server responds
+// with the codec of the request. On the server it sets the codec used to encode events,
+// event listeners must then decode them with Event.Decode, generated stubs decode events
+// with the client codec so the client must use the same codec. Default is msgpack.
+func WithCodec(codec Codec) Option {
+	return func(cfg *config) {
+		cfg.codec = codec
+	}
+}
+
+// WithCompression enables gzip compression of payloads larger than threshold bytes. The
+// client accepts compressed responses, and compresses the request arguments only if all
+// live instances of the module announce they support it, since older servers can't decode
+// compressed arguments. The server compresses the returns only if the request accepts
+// compressed responses. Payloads of JSON encoded messages are never compressed.
+func WithCompression(threshold int) Option {
+	return func(cfg *config) {
+		cfg.compression = threshold
+	}
+}
+
+// WithKeyring makes the client (or server) sign all requests (or responses) with the keyring current
+// key, and only accept responses (or requests) that are signed by one of the keyring keys. Unsigned
+// requests are dropped by the server, unsigned responses are ignored by the client.
+func WithKeyring(keyring *Keyring) Option {
+	return func(cfg *config) {
+		cfg.keyring = keyring
+	}
+}
+
+// WithPool configures the pool of connections to the broker
+func WithPool(pool PoolConfig) Option {
+	return func(cfg *config) {
+		cfg.pool = pool
+	}
+}
+
+// WithDialTimeout sets the timeout to connect to the broker. It overrides
+// the dial_timeout of the broker address.
+func WithDialTimeout(timeout time.Duration) Option {
+	return func(cfg *config) {
+		cfg.dial = append(cfg.dial, redis.DialConnectTimeout(timeout))
+	}
+}
+
+// WithReadTimeout sets the timeout to read a reply from the broker. Blocking
+// commands are not affected. It overrides the read_timeout of the broker address.
+func WithReadTimeout(timeout time.Duration) Option {
+	return func(cfg *config) {
+		cfg.dial = append(cfg.dial, redis.DialReadTimeout(timeout))
+	}
+}
+
+// WithWriteTimeout sets the timeout to send a command to the broker. It
+// overrides the write_timeout of the broker address.
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(cfg *config) {
+		cfg.dial = append(cfg.dial, redis.DialWriteTimeout(timeout))
+	}
+}
+
+// WithPullTimeout (server only) sets how long the server waits for requests before it
+// checks if it's stopped, a shorter timeout makes the server stop faster. The timeout is
+// rounded up to seconds. Default is 10 seconds.
+func WithPullTimeout(timeout time.Duration) Option {
+	return func(cfg *config) {
+		cfg.pull = timeout
+	}
+}
+
+// WithReplyTTL sets how long responses, stream items and blobs are kept in the broker
+// if they are not consumed, it's also how long a streaming call waits for a caller that
+// is not consuming its items. The timeout is rounded up to seconds. Default is 5 minutes.
+func WithReplyTTL(ttl time.Duration) Option {
+	return func(cfg *config) {
+		cfg.reply = ttl
+	}
+}
+
+// WithLogger sets the logger used by the server or client instead of the global logger
+func WithLogger(logger zerolog.Logger) Option {
+	return func(cfg *config) {
+		cfg.logger = &logger
+	}
+}
+
+// WithNamespace prefixes all queues, reply keys, events and other keys used by the
+// server (or client) with namespace, so multiple deployments can share the same
+// broker. Servers and clients only see each other if they use the same namespace.
+func WithNamespace(namespace string) Option {
+	return func(cfg *config) {
+		cfg.namespace = namespace
+	}
+}
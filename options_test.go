@@ -0,0 +1,37 @@
+package zbus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigTimeouts(t *testing.T) {
+	cfg := newConfig(nil)
+	require.Equal(t, redisPullTimeout, cfg.pullTimeout())
+	require.Equal(t, redisResponseTTL, cfg.responseTTL())
+
+	cfg = newConfig([]Option{
+		WithPullTimeout(1500 * time.Millisecond),
+		WithReplyTTL(time.Minute),
+	})
+	require.Equal(t, 2, cfg.pullTimeout())
+	require.Equal(t, 60, cfg.responseTTL())
+}
+
+func TestNewRedisPool(t *testing.T) {
+	pool, err := newRedisPool("tcp://localhost:6379", newConfig(nil))
+	require.NoError(t, err)
+	require.Equal(t, 100, pool.MaxActive)
+	require.Equal(t, time.Minute, pool.IdleTimeout)
+
+	pool, err = newRedisPool("tcp://localhost:6379", newConfig([]Option{
+		WithPool(PoolConfig{MaxActive: 5, MaxIdle: 2, IdleTimeout: time.Second}),
+		WithDialTimeout(time.Second),
+	}))
+	require.NoError(t, err)
+	require.Equal(t, 5, pool.MaxActive)
+	require.Equal(t, 2, pool.MaxIdle)
+	require.Equal(t, time.Second, pool.IdleTimeout)
+}
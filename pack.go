@@ -1,19 +1,22 @@
 package zbus
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"reflect"
+	"time"
 
 	"github.com/vmihailenco/msgpack"
 )
 
 type Tuple [][]byte
 
-func newTuple(args ...interface{}) (Tuple, error) {
+func newTuple(codec Codec, args ...interface{}) (Tuple, error) {
 	data := make([][]byte, 0, len(args))
 	for _, arg := range args {
 
-		bytes, err := msgpack.Marshal(arg)
+		bytes, err := codec.Marshal(arg)
 		if err != nil {
 			return nil, err
 		}
@@ -23,13 +26,47 @@ func newTuple(args ...interface{}) (Tuple, error) {
 	return data, nil
 }
 
-// Unmarshal argument at position i into value
+// Unmarshal argument at position i into value. The tuple must be encoded with
+// msgpack, use Decode for a tuple encoded with another codec.
 func (t Tuple) Unmarshal(i int, v interface{}) error {
+	return t.Decode(MsgPack, i, v)
+}
+
+// Decode decodes argument at position i, encoded with codec, into value
+func (t Tuple) Decode(codec Codec, i int, v interface{}) error {
 	if i < 0 || i >= len(t) {
 		return fmt.Errorf("index out of range")
 	}
 
-	return msgpack.Unmarshal(t[i], v)
+	return codec.Unmarshal(t[i], v)
+}
+
+// MarshalJSON encodes the tuple as a list of the (json encoded) elements
+func (t Tuple) MarshalJSON() ([]byte, error) {
+	elements := make([]json.RawMessage, 0, len(t))
+	for _, element := range t {
+		if len(element) == 0 {
+			element = []byte("null")
+		}
+		elements = append(elements, element)
+	}
+
+	return json.Marshal(elements)
+}
+
+// UnmarshalJSON decodes a list of json elements
+func (t *Tuple) UnmarshalJSON(data []byte) error {
+	var elements []json.RawMessage
+	if err := json.Unmarshal(data, &elements); err != nil {
+		return err
+	}
+
+	*t = make(Tuple, 0, len(elements))
+	for _, element := range elements {
+		*t = append(*t, []byte(element))
+	}
+
+	return nil
 }
 
 // Request is carrier of byte data. It does not assume any encoding types used for individual objects
@@ -39,27 +76,98 @@ type Request struct {
 	Object  ObjectID
 	ReplyTo string
 	Method  string
+	// Expires is the unix time in milliseconds after which the
+	// request should not be processed anymore. 0 means never.
+	Expires int64
+	// Time is the unix time in milliseconds when the request was created
+	Time int64
+	// Stream is set if the caller expects a stream of responses
+	Stream bool
+	// Blobs are the arguments sent out of band, by argument index.
+	// The inputs of these arguments are nil.
+	Blobs map[int]Blob
+	// ContentType is the content type of the codec used to encode
+	// the request and its arguments. Empty means msgpack.
+	ContentType string
+	// Compression is the compression algorithm of the inputs, empty
+	// if the inputs are not compressed
+	Compression string
+	// AcceptCompression is the compression algorithm the caller accepts
+	// for the response, empty if the caller doesn't support compression
+	AcceptCompression string
+	// AcceptBlobs is set if the caller accepts returns sent as blobs
+	AcceptBlobs bool
+	// Priority is the priority the request was sent with, so it's
+	// pushed back to the same queue
+	Priority Priority
+	// KeyID is the id of the key used to sign the request
+	KeyID string
+	// Signature is the request HMAC, empty if the request is not signed
+	Signature []byte
+
+	// caller is the authenticated caller identity
+	caller string
 }
 
 // NewRequest creates a message that carries the given values
 func NewRequest(id, replyTo string, object ObjectID, method string, args ...interface{}) (*Request, error) {
-	inputs, err := newTuple(args...)
+	return NewRequestWithCodec(MsgPack, id, replyTo, object, method, args...)
+}
+
+// NewRequestWithCodec creates a message that carries the given values encoded with codec
+func NewRequestWithCodec(codec Codec, id, replyTo string, object ObjectID, method string, args ...interface{}) (*Request, error) {
+	inputs, err := newTuple(codec, args...)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Request{
-		ID:      id,
-		Inputs:  inputs,
-		Object:  object,
-		ReplyTo: replyTo,
-		Method:  method,
+		ID:          id,
+		Inputs:      inputs,
+		Object:      object,
+		ReplyTo:     replyTo,
+		Method:      method,
+		Time:        unixMilli(time.Now()),
+		ContentType: codec.ContentType(),
 	}, nil
 }
 
+func unixMilli(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// SetExpiry sets the time after which the request should not be processed
+func (m *Request) SetExpiry(t time.Time) {
+	m.Expires = unixMilli(t)
+}
+
+// Expired checks if request has expired, a request with no expiry never expires
+func (m *Request) Expired() bool {
+	if m.Expires == 0 {
+		return false
+	}
+
+	return unixMilli(time.Now()) > m.Expires
+}
+
+// Waited returns how long ago the request was created. Returns 0 if
+// the request creation time is not set
+func (m *Request) Waited() time.Duration {
+	if m.Time == 0 {
+		return 0
+	}
+
+	return time.Duration(unixMilli(time.Now())-m.Time) * time.Millisecond
+}
+
+// codec returns the codec of the request
+func (m *Request) codec() Codec {
+	return getCodec(m.ContentType)
+}
+
 // Unmarshal argument at position i into value
 func (m *Request) Unmarshal(i int, v interface{}) error {
-	return m.Inputs.Unmarshal(i, v)
+	return m.Inputs.Decode(m.codec(), i, v)
 }
 
 // Value gets the concrete value stored at argument index i
@@ -79,8 +187,7 @@ func (m *Request) Argument(i int, t reflect.Type) (value reflect.Value, err erro
 	}
 
 	value = reflect.New(t)
-	element := value.Interface()
-	if err := msgpack.Unmarshal(m.Inputs[i], element); err != nil {
+	if err := m.Inputs.Decode(m.codec(), i, value.Interface()); err != nil {
 		return value, err
 	}
 
@@ -95,13 +202,21 @@ func (m *Request) NumArguments() int {
 // LoadRequest from bytes
 func LoadRequest(data []byte) (*Request, error) {
 	var request Request
-	return &request, msgpack.Unmarshal(data, &request)
+	if err := detect(data).Unmarshal(data, &request); err != nil {
+		return &request, err
+	}
+
+	if _, err := GetCodec(request.ContentType); err != nil {
+		return &request, err
+	}
+
+	return &request, request.decompress()
 }
 
 // Encode converts a message into byte data suitable to send over the wire
-// Encode will always use msgpack.
+// using the request codec.
 func (m *Request) Encode() ([]byte, error) {
-	return msgpack.Marshal(m)
+	return envelope(m.codec()).Marshal(m)
 }
 
 // CallError is a concrete type used to wrap all errors returned by services
@@ -118,10 +233,54 @@ func (r *CallError) Error() string {
 type Output struct {
 	Data  []byte
 	Error *CallError
+	// Blobs are the returns sent out of band, by return index
+	Blobs map[int]Blob
+	// Compression is the compression algorithm of data, empty
+	// if data is not compressed
+	Compression string
+
+	// blobs is the store used to load the blob returns
+	blobs BlobStore
+	// codec is the codec of data, nil means msgpack
+	codec Codec
+}
+
+// MarshalJSON encodes the output with the (json encoded) data inline
+func (t Output) MarshalJSON() ([]byte, error) {
+	type output Output
+	data := json.RawMessage(t.Data)
+	if len(data) == 0 {
+		data = json.RawMessage("null")
+	}
+
+	return json.Marshal(struct {
+		output
+		Data json.RawMessage
+	}{output(t), data})
 }
 
-func returnFromValues(values []reflect.Value) (Output, error) {
+// UnmarshalJSON decodes an output with inline data
+func (t *Output) UnmarshalJSON(data []byte) error {
+	type output Output
+	var value struct {
+		output
+		Data json.RawMessage
+	}
+
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	*t = Output(value.output)
+	t.Data = []byte(value.Data)
+	return nil
+}
+
+// returnFromValues builds the output of a call, the returns are encoded with codec.
+// io.Reader returns, and []byte returns larger than a blob chunk are stored in blobs.
+func returnFromValues(values []reflect.Value, codec Codec, blobs BlobStore) (Output, error) {
 	var objs []interface{}
+	var refs map[int]Blob
 	var err error
 	for _, res := range values {
 		typ := res.Type()
@@ -132,14 +291,28 @@ func returnFromValues(values []reflect.Value) (Output, error) {
 			continue
 		}
 		obj := res.Interface()
+		if _, ok := obj.(io.Reader); ok || blobs != nil && isBlob(obj) {
+			blob, err := putBlob(blobs, obj)
+			if err != nil {
+				return Output{}, err
+			}
+
+			if refs == nil {
+				refs = make(map[int]Blob)
+			}
+			refs[len(objs)] = blob
+			obj = nil
+		}
 		objs = append(objs, obj)
 	}
 
-	return returnFromObjects(err, objs...)
+	ret, encErr := returnFromObjects(codec, err, objs...)
+	ret.Blobs = refs
+	return ret, encErr
 }
 
-func returnFromObjects(err error, objs ...interface{}) (Output, error) {
-	var ret Output
+func returnFromObjects(codec Codec, err error, objs ...interface{}) (Output, error) {
+	ret := Output{codec: codec}
 	if err != nil {
 		ret.Error = &CallError{err.Error()}
 	}
@@ -151,9 +324,9 @@ func returnFromObjects(err error, objs ...interface{}) (Output, error) {
 	var data []byte
 	var encErr error
 	if len(objs) == 1 {
-		data, encErr = msgpack.Marshal(objs[0])
+		data, encErr = codec.Marshal(objs[0])
 	} else if len(objs) > 1 {
-		data, encErr = msgpack.Marshal(objs)
+		data, encErr = codec.Marshal(objs)
 	}
 
 	if encErr != nil {
@@ -168,11 +341,43 @@ func returnFromObjects(err error, objs ...interface{}) (Output, error) {
 func (t *Output) Unmarshal(v *Loader) error {
 	if len(*v) == 0 {
 		return nil
-	} else if len(*v) == 1 {
-		return msgpack.Unmarshal(t.Data, (*v)[0])
 	}
-	// in case is more we assume we have a longer list of objects
-	return msgpack.Unmarshal(t.Data, v)
+
+	codec := t.codec
+	if codec == nil {
+		codec = MsgPack
+	}
+
+	// keep the targets, decoding nil values may reset the loader entries
+	targets := append(Loader(nil), (*v)...)
+
+	var err error
+	if len(*v) == 1 {
+		err = codec.Unmarshal(t.Data, (*v)[0])
+	} else {
+		// in case is more we assume we have a longer list of objects
+		err = codec.Unmarshal(t.Data, v)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for i, blob := range t.Blobs {
+		if i < 0 || i >= len(targets) {
+			return fmt.Errorf("blob index out of range")
+		}
+
+		target := reflect.ValueOf(targets[i]).Elem()
+		value, err := loadBlob(t.blobs, blob, target.Type())
+		if err != nil {
+			return err
+		}
+
+		target.Set(value)
+	}
+
+	return nil
 }
 
 // Response object
@@ -184,6 +389,18 @@ type Response struct {
 	// Error here is any protocol error that is
 	// not related to error returned by the remote call
 	Error *string
+	// Seq is the sequence number of a streaming response item, starting
+	// from 1. It's 0 for normal responses.
+	Seq uint64
+	// End marks the end of a stream of responses
+	End bool
+	// ContentType is the content type of the codec used to encode
+	// the response and its returns, same as the request.
+	ContentType string
+	// KeyID is the id of the key used to sign the response
+	KeyID string
+	// Signature is the response HMAC, empty if the response is not signed
+	Signature []byte
 }
 
 // NewResponse creates a response with id, and errMsg and return values
@@ -228,15 +445,50 @@ func (m *Response) CallError() error {
 }
 
 // Encode converts a response into byte data suitable to send over the wire
-// Encode will always use msgpack.
+// using the response codec.
 func (m *Response) Encode() ([]byte, error) {
-	return msgpack.Marshal(m)
+	return envelope(getCodec(m.ContentType)).Marshal(m)
 }
 
 // LoadResponse loads response from data
 func LoadResponse(data []byte) (*Response, error) {
 	var response Response
-	return &response, msgpack.Unmarshal(data, &response)
+	if err := detect(data).Unmarshal(data, &response); err != nil {
+		return &response, err
+	}
+
+	codec, err := GetCodec(response.ContentType)
+	if err != nil {
+		return &response, err
+	}
+
+	response.Output.codec = codec
+	return &response, response.Output.decompress()
+}
+
+// StreamItem is an item of the input channel of a call, sent by the caller
+type StreamItem struct {
+	// Seq is the item sequence number, starting from 1
+	Seq uint64
+	// Data is the serialized item, encoded with the call codec
+	Data []byte
+	// End marks the end of the input stream
+	End bool
+	// KeyID is the id of the key used to sign the item
+	KeyID string
+	// Signature is the item HMAC, empty if the item is not signed
+	Signature []byte
+}
+
+// Encode converts a stream item into byte data suitable to send over the wire
+func (i *StreamItem) Encode() ([]byte, error) {
+	return msgpack.Marshal(i)
+}
+
+// LoadStreamItem loads a stream item from data
+func LoadStreamItem(data []byte) (*StreamItem, error) {
+	var item StreamItem
+	return &item, msgpack.Unmarshal(data, &item)
 }
 
 type Event []byte
@@ -244,3 +496,8 @@ type Event []byte
 func (e Event) Unmarshal(o interface{}) error {
 	return msgpack.Unmarshal(e, o)
 }
+
+// Decode decodes an event published by a server that uses codec
+func (e Event) Decode(codec Codec, o interface{}) error {
+	return codec.Unmarshal(e, o)
+}
@@ -3,6 +3,7 @@ package zbus
 import (
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -64,3 +65,44 @@ func TestRequest(t *testing.T) {
 		t.Error()
 	}
 }
+
+func TestRequestExpiry(t *testing.T) {
+	request, err := NewRequest("my-id", "", ObjectID{Name: "object"}, "DoSomething")
+	if ok := assert.NoError(t, err); !ok {
+		t.Fatal()
+	}
+
+	assert.False(t, request.Expired())
+
+	request.SetExpiry(time.Now().Add(time.Minute))
+	assert.False(t, request.Expired())
+
+	request.SetExpiry(time.Now().Add(-time.Second))
+
+	msg, err := request.Encode()
+	if ok := assert.NoError(t, err); !ok {
+		t.Fatal()
+	}
+
+	loaded, err := LoadRequest(msg)
+	if ok := assert.NoError(t, err); !ok {
+		t.Fatal()
+	}
+
+	assert.True(t, loaded.Expired())
+}
+
+func TestStreamItem(t *testing.T) {
+	item := StreamItem{Seq: 1, Data: []byte("data"), End: true}
+	data, err := item.Encode()
+	if ok := assert.NoError(t, err); !ok {
+		t.Fatal()
+	}
+
+	loaded, err := LoadStreamItem(data)
+	if ok := assert.NoError(t, err); !ok {
+		t.Fatal()
+	}
+
+	assert.Equal(t, &item, loaded)
+}
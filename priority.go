@@ -0,0 +1,105 @@
+package zbus
+
+import (
+	"context"
+	"fmt"
+)
+
+// Priority of a request. Servers always serve requests with higher
+// priority first.
+type Priority string
+
+const (
+	// PriorityHigh is used for interactive or health critical calls
+	PriorityHigh Priority = "high"
+	// PriorityNormal is the default priority
+	PriorityNormal Priority = ""
+	// PriorityLow is used for bulk operations
+	PriorityLow Priority = "low"
+)
+
+// priorities in the order they are served
+var priorities = []Priority{PriorityHigh, PriorityNormal, PriorityLow}
+
+type priorityKey struct{}
+
+// valid checks if p is one of the served priorities
+func (p Priority) valid() bool {
+	for _, priority := range priorities {
+		if p == priority {
+			return true
+		}
+	}
+
+	return false
+}
+
+// orNormal returns p, or PriorityNormal if p is unknown, since no
+// server pulls the queues of unknown priorities
+func (p Priority) orNormal() Priority {
+	if !p.valid() {
+		return PriorityNormal
+	}
+
+	return p
+}
+
+// WithPriority returns a context that makes all requests made with it
+// use priority p. Unknown priorities fall back to PriorityNormal.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// GetPriority returns the request priority associated with ctx
+func GetPriority(ctx context.Context) Priority {
+	p, _ := ctx.Value(priorityKey{}).(Priority)
+	return p.orNormal()
+}
+
+// priority returns the priority of a request to module made with ctx. Older
+// servers only pull the normal priority queue, so the request falls back to
+// the normal priority if a running server of module doesn't support priorities.
+// If no server is running, the request keeps its priority for the servers to come.
+func (c *RedisClient) priority(ctx context.Context, module string) Priority {
+	p := GetPriority(ctx)
+	if p == PriorityNormal {
+		return p
+	}
+
+	live, err := c.Instances(ctx, module)
+	if err != nil {
+		c.log().Error().Err(err).Msg("failed to check registry")
+		return PriorityNormal
+	}
+
+	for _, instance := range live {
+		if !instance.Supports(FeaturePriority) {
+			return PriorityNormal
+		}
+	}
+
+	return p
+}
+
+// queueName returns the name of the queue for object requests with priority p
+// the normal priority queue is `<module>.<object>`, other priorities use
+// `<module>.<object>.<priority>`
+func queueName(module string, object ObjectID, p Priority) string {
+	if p == PriorityNormal {
+		return fmt.Sprintf("%s.%s", module, object)
+	}
+
+	return fmt.Sprintf("%s.%s.%s", module, object, p)
+}
+
+// queueNames returns all the queues of objects in the order they should be served
+func queueNames(module string, objects []ObjectID) []string {
+	names := make([]string, 0, len(objects)*len(priorities))
+	for _, p := range priorities {
+		for _, id := range objects {
+			names = append(names, queueName(module, id, p))
+		}
+	}
+
+	return names
+}
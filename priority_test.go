@@ -0,0 +1,102 @@
+package zbus
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPriorityContext(t *testing.T) {
+	ctx := context.Background()
+	require.Equal(t, PriorityNormal, GetPriority(ctx))
+
+	ctx = WithPriority(ctx, PriorityHigh)
+	require.Equal(t, PriorityHigh, GetPriority(ctx))
+
+	// no server pulls unknown priorities
+	ctx = WithPriority(ctx, Priority("urgent"))
+	require.Equal(t, PriorityNormal, GetPriority(ctx))
+}
+
+func TestRequeuePriority(t *testing.T) {
+	con := &recordConn{}
+	server := &RedisServer{
+		module: "module",
+		pool:   &redis.Pool{Dial: func() (redis.Conn, error) { return con, nil }},
+		config: newConfig(nil),
+	}
+
+	object := ObjectID{Name: "calc"}
+	request, err := NewRequest("id", "reply-to", object, "Add", 1, 2)
+	require.NoError(t, err)
+	request.Priority = PriorityLow
+
+	data, err := request.Encode()
+	require.NoError(t, err)
+
+	loaded, err := LoadRequest(data)
+	require.NoError(t, err)
+	require.Equal(t, PriorityLow, loaded.Priority)
+
+	server.requeue([]*Request{loaded})
+	require.Len(t, con.commands, 1)
+	require.Equal(t, "LPUSH", con.commands[0][0])
+	require.Equal(t, "module.calc.low", con.commands[0][1])
+}
+
+func TestQueueNames(t *testing.T) {
+	require.Equal(t, "module.calc@1.0", queueName("module", ObjectID{Name: "calc", Version: "1.0"}, PriorityNormal))
+	require.Equal(t, "module.calc@1.0.high", queueName("module", ObjectID{Name: "calc", Version: "1.0"}, PriorityHigh))
+
+	names := queueNames("module", []ObjectID{{Name: "calc"}, {Name: "utils"}})
+	require.Equal(t, []string{
+		"module.calc.high",
+		"module.utils.high",
+		"module.calc",
+		"module.utils",
+		"module.calc.low",
+		"module.utils.low",
+	}, names)
+}
+
+func TestClientPriority(t *testing.T) {
+	ctx := WithPriority(context.Background(), PriorityHigh)
+
+	cases := []struct {
+		name     string
+		features []string
+		expected Priority
+	}{
+		{"supported", []string{FeaturePriority}, PriorityHigh},
+		// older servers only pull the normal queue
+		{"not supported", nil, PriorityNormal},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			con := &registryConn{registry: map[string]Instance{
+				"a": {ID: "a", Seen: time.Now(), Features: []string{FeaturePriority}},
+				"b": {ID: "b", Seen: time.Now(), Features: c.features},
+			}}
+			client := &RedisClient{
+				pool:   &redis.Pool{Dial: func() (redis.Conn, error) { return con, nil }},
+				config: newConfig(nil),
+			}
+
+			require.Equal(t, c.expected, client.priority(ctx, "module"))
+			// the registry is not checked for normal requests
+			require.Equal(t, PriorityNormal, client.priority(context.Background(), "module"))
+		})
+	}
+
+	// with no running server, the request keeps its priority for the servers to come
+	con := &registryConn{registry: map[string]Instance{}}
+	client := &RedisClient{
+		pool:   &redis.Pool{Dial: func() (redis.Conn, error) { return con, nil }},
+		config: newConfig(nil),
+	}
+	require.Equal(t, PriorityHigh, client.priority(ctx, "module"))
+}
@@ -3,14 +3,12 @@ package zbus
 import (
 	"context"
 	"fmt"
-	"net/url"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/vmihailenco/msgpack"
 
-	log "github.com/rs/zerolog/log"
+	"github.com/rs/zerolog"
 
 	"github.com/gomodule/redigo/redis"
 )
@@ -20,65 +18,83 @@ const (
 	redisResponseTTL = 5 * 60 // 5 minutes
 )
 
-func newRedisPool(address string) (*redis.Pool, error) {
-	u, err := url.Parse(address)
+func newRedisPool(address string, cfg config) (*redis.Pool, error) {
+	addr, err := parseAddress(address)
 	if err != nil {
 		return nil, err
 	}
-	var host string
-	switch u.Scheme {
-	case "tcp":
-		host = u.Host
-	case "unix":
-		host = u.Path
-	default:
-		return nil, fmt.Errorf("unknown scheme '%s' expecting tcp or unix", u.Scheme)
-	}
-	var opts []redis.DialOption
-
-	if u.User != nil {
-		opts = append(
-			opts,
-			redis.DialPassword(u.User.Username()),
-		)
-	}
 
-	return &redis.Pool{
+	pool := &redis.Pool{
 		Dial: func() (redis.Conn, error) {
-			return redis.Dial(u.Scheme, host, opts...)
-		},
-		TestOnBorrow: func(c redis.Conn, t time.Time) error {
-			if time.Since(t) > 10*time.Second {
-				//only check connection if more than 10 second of inactivity
-				_, err := c.Do("PING")
-				return err
-			}
-
-			return nil
+			return addr.dial(cfg.dial...)
 		},
 		MaxActive:   100,
+		MaxIdle:     cfg.pool.MaxIdle,
 		IdleTimeout: 1 * time.Minute,
 		Wait:        true,
-	}, nil
+	}
+
+	if cfg.pool.MaxActive > 0 {
+		pool.MaxActive = cfg.pool.MaxActive
+	}
+
+	if cfg.pool.IdleTimeout > 0 {
+		pool.IdleTimeout = cfg.pool.IdleTimeout
+	}
+
+	check := 10 * time.Second
+	if cfg.pool.TestOnBorrow > 0 {
+		check = cfg.pool.TestOnBorrow
+	}
+
+	pool.TestOnBorrow = func(c redis.Conn, t time.Time) error {
+		if time.Since(t) > check {
+			//only check connection after some inactivity
+			_, err := c.Do("PING")
+			return err
+		}
+
+		return nil
+	}
+
+	return pool, nil
 }
 
 // RedisServer implementation for Redis
 type RedisServer struct {
 	BaseServer
-	module  string
-	pool    *redis.Pool
-	workers uint
-	running bool
+	module   string
+	pool     *redis.Pool
+	workers  uint
+	instance Instance
+	config   config
+	running  bool
+	// stopping is set once the server started draining
+	stopping bool
+	// drained is closed once the last drain is complete, including
+	// the calls and streams it gave up waiting for
+	drained chan struct{}
 	state   sync.Mutex
+
+	// ctx is the context the server is running with
+	ctx context.Context
+	// workerCtx is cancelled to stop all workers
+	workerCtx context.Context
+	workersWg sync.WaitGroup
+
+	feeders   map[*feeder]struct{}
+	feedersM  sync.Mutex
+	feedersWg sync.WaitGroup
 }
 
 // NewRedisServer builds a new ZBus server that uses disque as message broker
-func NewRedisServer(module, address string, workers uint) (Server, error) {
+func NewRedisServer(module, address string, workers uint, opts ...Option) (Server, error) {
 	if workers == 0 {
 		return nil, fmt.Errorf("invalid number of workers")
 	}
 
-	pool, err := newRedisPool(address)
+	cfg := newConfig(opts)
+	pool, err := newRedisPool(address, cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -90,65 +106,153 @@ func NewRedisServer(module, address string, workers uint) (Server, error) {
 		return nil, fmt.Errorf("could not establish connection: %s", err)
 	}
 
-	return &RedisServer{module: module, pool: pool, workers: workers}, nil
+	server := &RedisServer{
+		module:   module,
+		pool:     pool,
+		workers:  workers,
+		instance: newInstance(module, workers),
+		config:   cfg,
+	}
+
+	if cfg.logger != nil {
+		server.SetLogger(*cfg.logger)
+	}
+
+	server.OnFailure(server.failed)
+	server.OnStreamResponse(server.scb)
+	server.OnStreamInput(server.icb)
+	server.SetBlobStore(&redisBlobs{pool: pool, ttl: cfg.responseTTL(), namespace: cfg.namespace})
+	server.SetLoadShedding(server.config.shedding)
+	return server, nil
 }
 
 func (s *RedisServer) cb(request *Request, response *Response) {
 	con := s.pool.Get()
 	defer con.Close()
-	payload, err := response.Encode()
-	if err != nil {
-		log.Error().Err(err).Msg("failed to encode response")
+	response.ContentType = request.ContentType
+	response.sign(s.config.keyring)
+	if err := response.compress(request, s.config.compression); err != nil {
+		s.log().Error().Err(err).Msg("failed to compress response")
 		return
 	}
 
-	if err := con.Send("RPUSH", request.ReplyTo, payload); err != nil {
-		log.Error().Err(err).Msg("failed to send response")
+	payload, err := response.Encode()
+	if err != nil {
+		s.log().Error().Err(err).Msg("failed to encode response")
 		return
 	}
 
-	con.Send("EXPIRE", request.ReplyTo, redisResponseTTL)
+	con.Send("RPUSH", request.ReplyTo, payload)
+	con.Send("EXPIRE", request.ReplyTo, s.config.responseTTL())
+	if _, err := con.Do(""); err != nil {
+		s.log().Error().Err(err).Msg("failed to send response")
+	}
 }
 
 // ecb event callback
 func (s *RedisServer) ecb(key string, o interface{}) {
 	con := s.pool.Get()
 	defer con.Close()
-	data, err := msgpack.Marshal(o)
+	data, err := s.config.encoding().Marshal(o)
 	if err != nil {
-		log.Error().Err(err).Msg("failed to encode event")
+		s.log().Error().Err(err).Msg("failed to encode event")
 		return
 	}
 
 	key = fmt.Sprintf("%s.%s", s.module, key)
 
-	if err := con.Send("PUBLISH", key, data); err != nil {
-		log.Error().Err(err).Msg("failed to send event")
+	if s.config.events > 0 {
+		if err := s.durable(con, key, data); err != nil {
+			s.log().Error().Err(err).Msg("failed to store event")
+			return
+		}
+	}
+
+	if err := con.Send("PUBLISH", s.config.key(key), data); err != nil {
+		s.log().Error().Err(err).Msg("failed to send event")
+		return
+	}
+
+	// the replies carry the error of a failed append
+	if _, err := con.Do(""); err != nil {
+		s.log().Error().Err(err).Str("event", key).Msg("failed to send event")
 	}
 }
 
-func (s *RedisServer) getNext(pullArgs []interface{}) ([]byte, error) {
+// getNext pulls the next payload, and returns the queue it was received from
+func (s *RedisServer) getNext(pullArgs []interface{}) (string, []byte, error) {
 	con := s.pool.Get()
 	defer con.Close()
 
-	payload, err := redis.ByteSlices(con.Do("BLPOP", pullArgs...))
+	payload, err := redis.ByteSlices(block(con, time.Duration(s.config.pullTimeout())*time.Second, "BLPOP", pullArgs...))
 	if err != nil {
-		return nil, err
+		return "", nil, err
 	}
 
 	if payload == nil || len(payload) < 2 {
-		return nil, redis.ErrNil
+		return "", nil, redis.ErrNil
 	}
 
-	return payload[1], nil
+	return string(payload[0]), payload[1], nil
 }
 
-func (s *RedisServer) statusHandler(ctx context.Context) error {
-	pullArgs := []interface{}{
-		fmt.Sprintf("%s.%s", s.module, statusObjectID),
-		redisPullTimeout,
+// load a request payload received from queue. Returns nil if the request
+// is not valid or has expired.
+func (s *RedisServer) load(queue string, payload []byte) *Request {
+	request, err := LoadRequest(payload)
+	if err != nil {
+		s.log().Error().Err(err).Msg("failed to load request object")
+		s.deadLetter(queue, payload, ReasonInvalid, err.Error())
+		return nil
 	}
 
+	if s.config.keyring != nil {
+		if err := request.Verify(s.config.keyring); err != nil {
+			s.log().Warn().Str("request", request.ID).Msgf("discarding unauthenticated request to %s.%s()", request.Object, request.Method)
+			s.deadLetter(queue, payload, ReasonUnauthenticated, err.Error())
+			return nil
+		}
+
+		if err := s.fresh(request); err != nil {
+			s.log().Warn().Err(err).Str("request", request.ID).Msgf("discarding replayed request to %s.%s()", request.Object, request.Method)
+			s.deadLetter(queue, payload, ReasonReplayed, err.Error())
+			return nil
+		}
+
+		request.caller = request.KeyID
+	}
+
+	if request.Expired() {
+		s.log().Warn().Str("request", request.ID).Msgf("discarding expired request to %s.%s()", request.Object, request.Method)
+		s.deadLetter(queue, payload, ReasonExpired, "")
+		return nil
+	}
+
+	return request
+}
+
+// fresh checks that a signed request was made within the replay window, and was
+// not served before, so requests captured from the broker can't be replayed
+func (s *RedisServer) fresh(request *Request) error {
+	if waited := request.Waited(); request.Time == 0 || waited > replayWindow || waited < -replayWindow {
+		return errReplayed
+	}
+
+	con := s.pool.Get()
+	defer con.Close()
+
+	ttl := int64(2 * replayWindow / time.Millisecond)
+	_, err := redis.String(con.Do("SET", s.config.key(seenKey(request.ID)), 1, "NX", "PX", ttl))
+	if err == redis.ErrNil {
+		return errReplayed
+	}
+
+	return err
+}
+
+func (s *RedisServer) statusHandler(ctx context.Context, wake string) error {
+	pullArgs := append(s.queues(statusObjectID), wake, s.config.pullTimeout())
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -156,7 +260,7 @@ func (s *RedisServer) statusHandler(ctx context.Context) error {
 		default:
 		}
 
-		payload, err := s.getNext(pullArgs)
+		queue, payload, err := s.getNext(pullArgs)
 
 		if err == redis.ErrNil {
 			select {
@@ -166,20 +270,24 @@ func (s *RedisServer) statusHandler(ctx context.Context) error {
 			}
 			continue
 		} else if err != nil {
-			log.Error().Err(err).Msg("failed to get next job. Retrying in 1 second")
+			s.log().Error().Err(err).Msg("failed to get next job. Retrying in 1 second")
 			<-time.After(1 * time.Second)
 			continue
 		}
 
-		request, err := LoadRequest(payload)
-		if err != nil {
-			log.Error().Err(err).Msg("failed to load request object")
+		if queue == wake {
+			// woken up to check ctx
+			continue
+		}
+
+		request := s.load(queue, payload)
+		if request == nil {
 			continue
 		}
 
-		status, err := returnFromObjects(nil, s.Status())
+		status, err := returnFromObjects(request.codec(), nil, s.Status())
 		if err != nil {
-			log.Error().Err(err).Msg("failed to create response")
+			s.log().Error().Err(err).Msg("failed to create response")
 			continue
 		}
 		response := NewResponse(request.ID, status, "")
@@ -189,7 +297,11 @@ func (s *RedisServer) statusHandler(ctx context.Context) error {
 	}
 }
 
-// Run starts the ZBus server
+// Run starts the ZBus server. Once ctx is cancelled the server stops pulling new
+// requests and waits for in-flight calls and streams to finish. If they don't
+// finish within the drain timeout, Run returns a *DrainError that lists them. The
+// server can be run again once Run returns, unless calls or streams it abandoned
+// are still running, Run then fails until they are done.
 func (s *RedisServer) Run(ctx context.Context) error {
 	//don't run multiple instances at the same time
 	s.state.Lock()
@@ -197,85 +309,173 @@ func (s *RedisServer) Run(ctx context.Context) error {
 		s.state.Unlock()
 		return fmt.Errorf("server is already running")
 	}
-	var pullArgs []interface{}
-	//fill in the queues to pull from, we have a queue per object
-	for id := range s.objects {
-		pullArgs = append(
-			pullArgs,
-			fmt.Sprintf("%s.%s", s.module, id),
-		)
+
+	if s.drained != nil {
+		select {
+		case <-s.drained:
+		default:
+			// the workers of the last run are still in use
+			s.state.Unlock()
+			return fmt.Errorf("server is still stopping")
+		}
 	}
 
 	s.running = true
-	s.state.Unlock()
+	s.ctx = ctx
+	s.drained = make(chan struct{})
+	s.instance.Started = time.Now()
+
+	var handlers sync.WaitGroup
+	handlers.Add(2)
+
+	//announce this instance in the registry
+	go func() {
+		defer handlers.Done()
+		s.heartbeatHandler(ctx)
+	}()
 
 	//status handler runs in its own worker.
-	go s.statusHandler(ctx)
+	statusWake := s.config.key(fmt.Sprintf("%s.%s.status", wakePrefix, s.instance.ID))
+	go func() {
+		defer handlers.Done()
+		s.statusHandler(ctx, statusWake)
+	}()
 
 	//start event workers
 	s.StartStreams(ctx, s.ecb)
+	// streaming calls are not stopped with ctx, but by the drain
+	stopCalls := s.startCalls()
 
 	// now start request/response workers and proxy calls and responses
-	pullArgs = append(pullArgs, redisPullTimeout) //the pull timeout
 	workerCtx, shutdown := context.WithCancel(context.Background())
-	var wg sync.WaitGroup
-	ch := s.Start(workerCtx, &wg, s.workers, s.cb)
+	s.workerCtx = workerCtx
+
+	// the shared pool serves all objects that has no dedicated workers
+	s.startPool(s.workers, s.shared)
+	for _, id := range s.objectIDs() {
+		s.startDedicated(id)
+	}
+	s.state.Unlock()
+
+	<-ctx.Done()
+	s.log().Info().Str("module", s.module).Msg("shutting down, waiting for in-flight calls")
+
+	// no new pools can be started once the drain waits for the feeders
+	s.state.Lock()
+	s.stopping = true
+	s.state.Unlock()
 
+	calls, streams := s.drain(shutdown, stopCalls, s.drained)
+
+	// the handlers stop with ctx, the status handler is woken up so it
+	// doesn't wait for its pull to time out. The heartbeat handler removes
+	// the instance from the registry on its way out
+	con := s.pool.Get()
+	sendWake(con, statusWake)
+	if _, err := con.Do(""); err != nil {
+		s.log().Error().Err(err).Msg("failed to wake status handler")
+	}
+	con.Close()
+	handlers.Wait()
+
+	s.state.Lock()
+	s.running, s.stopping = false, false
+	s.state.Unlock()
+
+	if len(calls) != 0 || streams != 0 {
+		err := &DrainError{Err: ctx.Err(), Calls: calls, Streams: streams}
+		s.log().Warn().Err(err).Msg("shutdown did not complete in time")
+		return err
+	}
+
+	return ctx.Err()
+}
+
+// Register registers an object on the server. Objects can be registered
+// while the server is running, but not once it's shutting down.
+func (s *RedisServer) Register(id ObjectID, object interface{}) error {
+	return s.RegisterWith(id, object)
+}
+
+// RegisterWith registers an object on the server with options
+func (s *RedisServer) RegisterWith(id ObjectID, object interface{}, opts ...ObjectOption) error {
+	var changed bool
 	defer func() {
-		shutdown()
-		wg.Wait()
-		close(ch)
+		// heartbeat takes the state lock, so it's sent once it's released
+		if changed {
+			s.announce()
+		}
 	}()
 
-	for {
-		// wait for free worker before we poll for jobs
-		select {
-		case ch <- &NoOP:
-		case <-ctx.Done():
-			return ctx.Err()
-		}
+	s.state.Lock()
+	defer s.state.Unlock()
 
-		payload, err := s.getNext(pullArgs)
+	if s.stopping || (s.running && s.ctx.Err() != nil) {
+		return fmt.Errorf("server is shutting down")
+	}
 
-		if err == redis.ErrNil {
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			default:
-			}
-			continue
-		} else if err != nil {
-			log.Error().Err(err).Msg("failed to get next job. Retrying in 1 second")
-			<-time.After(1 * time.Second)
-			continue
-		}
+	if err := s.BaseServer.RegisterWith(id, object, opts...); err != nil {
+		return err
+	}
 
-		request, err := LoadRequest(payload)
-		if err != nil {
-			log.Error().Err(err).Msg("failed to load request object")
-			continue
+	if s.running {
+		s.startDedicated(id)
+		s.wake()
+		changed = true
+	}
+
+	return nil
+}
+
+// Unregister removes an object from the server. In-flight calls to the
+// object are allowed to finish, but no new requests are pulled for it.
+func (s *RedisServer) Unregister(id ObjectID) error {
+	var changed bool
+	defer func() {
+		if changed {
+			s.announce()
 		}
+	}()
+
+	s.state.Lock()
+	defer s.state.Unlock()
 
-		// force wait for a worker to poll
-		// the job (since we sure there is one free)
-		// we don't allow shutting the workers down here.
-		ch <- request
+	if err := s.BaseServer.Unregister(id); err != nil {
+		return err
 	}
+
+	if s.running {
+		s.wake()
+		changed = true
+	}
+
+	return nil
 }
 
 // RedisClient is client implementation for redis broker
 type RedisClient struct {
-	pool *redis.Pool
+	pool          *redis.Pool
+	config        config
+	subscriptions *subscriptions
 }
 
 // NewRedisClient creates a new redis client
-func NewRedisClient(address string) (Client, error) {
-	pool, err := newRedisPool(address)
+func NewRedisClient(address string, opts ...Option) (Client, error) {
+	cfg := newConfig(opts)
+	pool, err := newRedisPool(address, cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	return &RedisClient{pool}, nil
+	return &RedisClient{
+		pool:          pool,
+		config:        cfg,
+		subscriptions: newSubscriptions(pool, cfg.log()),
+	}, nil
+}
+
+func (c *RedisClient) log() *zerolog.Logger {
+	return c.config.log()
 }
 
 // Request makes a request to object.Method hosted by module. A module name is the queue name used in the server part.
@@ -284,13 +484,36 @@ func (c *RedisClient) Request(module string, object ObjectID, method string, arg
 }
 
 // RequestContext makes a request to object.Method hosted by module. A module name is the queue name used in the server part.
+// The request priority can be set on the context with WithPriority. If one of the arguments is a channel, the items received
+// from the channel are streamed to the input channel of the method until the channel is closed.
 func (c *RedisClient) RequestContext(ctx context.Context, module string, object ObjectID, method string, args ...interface{}) (*Response, error) {
+	args, in := input(args)
+	args, blobs, err := c.upload(ctx, module, args)
+	if err != nil {
+		return nil, err
+	}
+
 	id := uuid.New().String()
-	request, err := NewRequest(id, id, object, method, args...)
+	replyTo := c.config.key(id)
+	request, err := NewRequestWithCodec(c.config.encoding(), id, replyTo, object, method, args...)
 	if err != nil {
 		return nil, err
 	}
 
+	request.Blobs = blobs
+	request.AcceptBlobs = true
+	request.Priority = c.priority(ctx, module)
+	if expiry, ok := c.expiry(ctx); ok {
+		request.SetExpiry(expiry)
+	}
+
+	// signing must come after all signed fields are set
+	request.accept(c.config.compression)
+	request.sign(c.config.keyring)
+	if err := c.compress(ctx, module, request); err != nil {
+		return nil, err
+	}
+
 	payload, err := request.Encode()
 	if err != nil {
 		return nil, err
@@ -300,33 +523,153 @@ func (c *RedisClient) RequestContext(ctx context.Context, module string, object
 	if err != nil {
 		return nil, err
 	}
-	defer con.Close()
-	queue := fmt.Sprintf("%s.%s", module, object)
+	defer func() {
+		// con is nil if it failed to reconnect
+		if con != nil {
+			con.Close()
+		}
+	}()
+
+	var check <-chan time.Time
+	if c.config.failFast > 0 {
+		if !c.serving(con, module, object) {
+			return nil, ErrNoServer
+		}
+
+		ticker := time.NewTicker(c.config.failFast)
+		defer ticker.Stop()
+		check = ticker.C
+	}
+	queue := c.config.key(queueName(module, object, request.Priority))
 	if err := con.Send("RPUSH", queue, payload); err != nil {
 		return nil, err
 	}
 
+	if in.IsValid() {
+		// stop sending input once the call is done
+		ctx, stop := context.WithCancel(ctx)
+		defer stop()
+		go c.send(ctx, id, replyTo, in)
+	}
+
 	// wait for response
 	for {
-		response, err := c.getResponse(con, id)
+		response, err := c.getResponse(con, replyTo)
 		if err == redis.ErrNil {
 			select {
 			case <-ctx.Done():
+				if in.IsValid() {
+					// the server might be waiting for more input
+					c.cancelStream(con, replyTo)
+				}
 				return nil, ctx.Err()
+			case <-check:
+				if err := c.served(con, module, object, queue, payload); err != nil {
+					return nil, err
+				}
 			default:
-				continue
 			}
+			continue
+		} else if err != nil && con.Err() != nil {
+			// the server can still respond once we are connected again
+			if con, err = c.reconnect(ctx, con); err != nil {
+				return nil, err
+			}
+			continue
+		} else if err != nil {
+			return nil, err
 		}
 
 		return response, nil
 	}
 }
 
-func (c *RedisClient) getResponse(con redis.Conn, id string) (*Response, error) {
-	//TODO: a timeout or an exit strategy is required here in case
-	//the response never came back
+// reconnect replaces the broken connection con while waiting for a response, for example
+// after a failover of the broker. It retries until it's connected, ctx is done, or any
+// response would have expired.
+func (c *RedisClient) reconnect(ctx context.Context, con redis.Conn) (redis.Conn, error) {
+	broken := con.Err()
+	con.Close()
+	c.log().Debug().Err(broken).Msg("lost connection while waiting for response. reconnecting")
+
+	deadline := time.Now().Add(time.Duration(c.config.responseTTL()) * time.Second)
+	backoff := 100 * time.Millisecond
+	for {
+		con, err := c.pool.GetContext(ctx)
+		if err == nil {
+			if err = con.Err(); err == nil {
+				return con, nil
+			}
+			con.Close()
+		} else if ctx.Err() != nil {
+			return nil, err
+		}
+
+		if time.Now().Add(backoff).After(deadline) {
+			return nil, broken
+		}
 
-	payload, err := redis.ByteSlices(con.Do("BLPOP", id, 1))
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		if backoff *= 2; backoff > streamMaxBackoff {
+			backoff = streamMaxBackoff
+		}
+	}
+}
+
+// expiry of a request made with ctx
+func (c *RedisClient) expiry(ctx context.Context) (time.Time, bool) {
+	deadline, ok := ctx.Deadline()
+	if c.config.ttl > 0 {
+		expiry := time.Now().Add(c.config.ttl)
+		if !ok || expiry.Before(deadline) {
+			return expiry, true
+		}
+	}
+
+	return deadline, ok
+}
+
+// serving checks the registry for a live server instance that serves object. If the
+// registry can't be checked, object is assumed to be served so the call keeps waiting
+// for its response as if fail fast was disabled.
+func (c *RedisClient) serving(con redis.Conn, module string, object ObjectID) bool {
+	live, _, err := instances(con, c.config.key(registryKey(module)))
+	if err != nil {
+		c.log().Error().Err(err).Msg("failed to check registry")
+		return true
+	}
+
+	for _, instance := range live {
+		if instance.Serves(object) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// served makes sure there is still a live server instance that serves object
+// otherwise the request payload is withdrawn from the queue and ErrNoServer is returned
+func (c *RedisClient) served(con redis.Conn, module string, object ObjectID, queue string, payload []byte) error {
+	if c.serving(con, module, object) {
+		return nil
+	}
+
+	if _, err := con.Do("LREM", queue, 1, payload); err != nil {
+		c.log().Error().Err(err).Str("queue", queue).Msg("failed to withdraw request")
+	}
+
+	return ErrNoServer
+}
+
+// getResponse waits for the next response pushed to the replyTo key of a request
+func (c *RedisClient) getResponse(con redis.Conn, replyTo string) (*Response, error) {
+	payload, err := redis.ByteSlices(block(con, time.Second, "BLPOP", replyTo, 1))
 	if err != nil {
 		return nil, err
 	}
@@ -340,13 +683,28 @@ func (c *RedisClient) getResponse(con redis.Conn, id string) (*Response, error)
 		return nil, err
 	}
 
+	if c.config.keyring != nil {
+		if err := response.Verify(c.config.keyring); err != nil {
+			// a response pushed by someone else, keep waiting
+			// for the actual response
+			c.log().Warn().Str("reply", replyTo).Msg("discarding unauthenticated response")
+			return nil, redis.ErrNil
+		}
+	}
+
 	if response.Error != nil {
-		return nil, fmt.Errorf(*response.Error)
+		return nil, protocolError(*response.Error)
 	}
 
+	response.Output.blobs = c.blobs()
 	return response, nil
 }
 
+// Codec returns the codec the client encodes requests with
+func (c *RedisClient) Codec() Codec {
+	return c.config.encoding()
+}
+
 // Status return module status
 func (c *RedisClient) Status(ctx context.Context, module string) (Status, error) {
 	response, err := c.RequestContext(ctx, module, statusObjectID, "")
@@ -364,51 +722,3 @@ func (c *RedisClient) Status(ctx context.Context, module string) (Status, error)
 
 	return status, nil
 }
-
-// Stream listens to a stream of events from the server
-func (c *RedisClient) Stream(ctx context.Context, module string, object ObjectID, event string) (<-chan Event, error) {
-	con, err := c.pool.GetContext(ctx)
-	if err != nil {
-		return nil, err
-	}
-
-	key := fmt.Sprintf("%s.%s.%s", module, object, event)
-	_, err = con.Do("SUBSCRIBE", key)
-
-	if err != nil {
-		con.Close()
-		return nil, err
-	}
-
-	ch := make(chan Event)
-	go func(con redis.Conn) {
-		defer func() {
-			close(ch)
-			con.Send("UNSUBSCRIBE")
-			con.Close()
-		}()
-
-		for {
-			message, err := redis.ByteSlices(con.Receive())
-			if err != nil {
-				log.Error().Err(err).Msgf("failed to get next event for '%s'", key)
-				return
-			}
-
-			if len(message) != 3 {
-				log.Debug().Str("key", key).Msgf("message was of len (%d)", len(message))
-				continue
-			}
-			// problem with cancellation here is that
-			// it won't actually happen unless we received
-			// a message on the subscribe channel
-			select {
-			case ch <- Event(message[2]):
-			case <-ctx.Done():
-				return
-			}
-		}
-	}(con)
-
-	return ch, nil
-}
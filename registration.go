@@ -0,0 +1,118 @@
+package zbus
+
+import "context"
+
+// ObjectOption configures how a registered object is served
+type ObjectOption func(*objectConfig)
+
+type objectConfig struct {
+	// workers is the size of the object dedicated worker
+	// pool. 0 means the object is served by the shared workers
+	workers uint
+	// limits is the max number of concurrent calls per method
+	limits map[string]uint
+	// rates are the rate limits per method
+	rates map[string]rateLimit
+	// pressure are the back pressure policies per stream
+	pressure map[string]BackPressure
+	// callers are the allowed callers per method
+	callers map[string]map[string]struct{}
+}
+
+type rateLimit struct {
+	rate  float64
+	burst uint
+}
+
+func newObjectConfig(opts []ObjectOption) objectConfig {
+	var cfg objectConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return cfg
+}
+
+// WithDedicatedWorkers serves the object with its own pool of n workers instead
+// of the workers shared by all objects. So a slow object can't starve other
+// objects of workers.
+func WithDedicatedWorkers(n uint) ObjectOption {
+	return func(cfg *objectConfig) {
+		cfg.workers = n
+	}
+}
+
+// WithConcurrency limits the number of concurrent calls to method to n. Extra
+// calls are queued and don't occupy a worker until a running call is done. Up to
+// 128 calls are queued per method, more calls fail with ErrOverloaded.
+func WithConcurrency(method string, n uint) ObjectOption {
+	return func(cfg *objectConfig) {
+		if cfg.limits == nil {
+			cfg.limits = make(map[string]uint)
+		}
+
+		cfg.limits[method] = n
+	}
+}
+
+// WithRateLimit limits the calls to method to rate calls per second, with
+// bursts of up to burst calls. An empty method name limits the calls to all
+// methods of the object. Calls exceeding the limit fail with ErrRateLimited.
+func WithRateLimit(method string, rate float64, burst uint) ObjectOption {
+	return func(cfg *objectConfig) {
+		if cfg.rates == nil {
+			cfg.rates = make(map[string]rateLimit)
+		}
+
+		cfg.rates[method] = rateLimit{rate: rate, burst: burst}
+	}
+}
+
+// WithStreamBackPressure sets the back pressure policy of stream. By default a stream
+// blocks until its events are published. The number of events dropped by the
+// policy is reported in the server status.
+func WithStreamBackPressure(stream string, policy BackPressure) ObjectOption {
+	return func(cfg *objectConfig) {
+		if cfg.pressure == nil {
+			cfg.pressure = make(map[string]BackPressure)
+		}
+
+		cfg.pressure[stream] = policy
+	}
+}
+
+// registeredObject is an object registered on the server
+type registeredObject struct {
+	surrogate *Surrogate
+	config    objectConfig
+	limiters  map[string]*limiter
+	rates     map[string]*bucket
+	pressure  map[string]*BackPressure
+	// stop stops the object streams
+	stop context.CancelFunc
+}
+
+func newRegisteredObject(value interface{}, opts []ObjectOption) *registeredObject {
+	obj := &registeredObject{
+		surrogate: NewSurrogate(value),
+		config:    newObjectConfig(opts),
+		limiters:  make(map[string]*limiter),
+		rates:     make(map[string]*bucket),
+		pressure:  make(map[string]*BackPressure),
+	}
+
+	for method, n := range obj.config.limits {
+		obj.limiters[method] = newLimiter(n)
+	}
+
+	for method, limit := range obj.config.rates {
+		obj.rates[method] = newBucket(limit.rate, limit.burst)
+	}
+
+	for stream, policy := range obj.config.pressure {
+		policy := policy
+		obj.pressure[stream] = &policy
+	}
+
+	return obj
+}
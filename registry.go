@@ -0,0 +1,310 @@
+package zbus
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/google/uuid"
+	"github.com/vmihailenco/msgpack"
+)
+
+const (
+	registryPrefix    = "zbus.registry"
+	heartbeatInterval = 10 * time.Second
+	heartbeatTTL      = 30 // seconds, must be larger than heartbeatInterval
+
+	// FeatureBlobs is announced by servers that accept large []byte
+	// arguments sent as blobs
+	FeatureBlobs = "blobs"
+	// FeatureCompression is announced by servers that accept compressed
+	// request arguments
+	FeatureCompression = "compression"
+	// FeaturePriority is announced by servers that pull the high and
+	// low priority queues
+	FeaturePriority = "priority"
+)
+
+var (
+	// ErrNoServer is returned if there is no live server instance that
+	// serves the requested object
+	ErrNoServer = fmt.Errorf("no server is serving this object")
+)
+
+// Instance describes a single running server of a module. Instances are
+// announced to the broker by the server heartbeat, and expire if the server
+// stops sending heartbeats.
+type Instance struct {
+	Module   string     `json:"module" yaml:"module"`
+	ID       string     `json:"id" yaml:"id"`
+	Hostname string     `json:"hostname" yaml:"hostname"`
+	PID      int        `json:"pid" yaml:"pid"`
+	Objects  []ObjectID `json:"objects" yaml:"objects"`
+	Workers  uint       `json:"workers" yaml:"workers"`
+	Started  time.Time  `json:"started" yaml:"started"`
+	Seen     time.Time  `json:"seen" yaml:"seen"`
+	// Features are the optional protocol features the instance supports
+	Features []string `json:"features" yaml:"features"`
+}
+
+// Serves checks if instance serves the given object
+func (i *Instance) Serves(object ObjectID) bool {
+	if object == statusObjectID {
+		// status object is always served by any running instance
+		return true
+	}
+
+	for _, id := range i.Objects {
+		if id == object {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Supports checks if instance supports the given feature
+func (i *Instance) Supports(feature string) bool {
+	for _, f := range i.Features {
+		if f == feature {
+			return true
+		}
+	}
+
+	return false
+}
+
+// registryKey is the hash of the live instances of module, by instance id
+func registryKey(module string) string {
+	return fmt.Sprintf("%s.%s", registryPrefix, module)
+}
+
+// alive checks if the instance sent a heartbeat recently
+func (i *Instance) alive() bool {
+	return time.Since(i.Seen) < heartbeatTTL*time.Second
+}
+
+func newInstance(module string, workers uint) Instance {
+	hostname, _ := os.Hostname()
+	return Instance{
+		Module:   module,
+		ID:       uuid.New().String(),
+		Hostname: hostname,
+		PID:      os.Getpid(),
+		Workers:  workers,
+		Features: []string{FeatureBlobs, FeatureCompression, FeaturePriority},
+	}
+}
+
+// workersCount returns the number of shared and dedicated workers
+func (s *RedisServer) workersCount() uint {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	workers := s.workers
+	for _, obj := range s.objects {
+		workers += obj.config.workers
+	}
+
+	return workers
+}
+
+func (s *RedisServer) heartbeat() error {
+	s.state.Lock()
+	instance := s.instance
+	s.state.Unlock()
+
+	instance.Objects = s.objectIDs()
+	instance.Workers = s.workersCount()
+	instance.Seen = time.Now()
+
+	data, err := msgpack.Marshal(instance)
+	if err != nil {
+		return err
+	}
+
+	con := s.pool.Get()
+	defer con.Close()
+
+	key := s.config.key(registryKey(instance.Module))
+	con.Send("MULTI")
+	con.Send("HSET", key, instance.ID, data)
+	// the hash is gone once no instance of the module is alive
+	con.Send("EXPIRE", key, heartbeatTTL)
+	con.Send("SADD", s.config.key(registryPrefix), instance.Module)
+	if _, err := con.Do("EXEC"); err != nil {
+		return err
+	}
+
+	// remove instances that stopped without unregistering
+	_, stale, err := instances(con, key)
+	if err != nil || len(stale) == 0 {
+		return err
+	}
+
+	_, err = con.Do("HDEL", redis.Args{}.Add(key).AddFlat(stale)...)
+	return err
+}
+
+// announce sends a heartbeat. It's also called once the objects change, so
+// clients see them without waiting for the next heartbeat.
+func (s *RedisServer) announce() {
+	if err := s.heartbeat(); err != nil {
+		s.log().Error().Err(err).Msg("failed to send heartbeat")
+	}
+}
+
+func (s *RedisServer) unregister() {
+	con := s.pool.Get()
+	defer con.Close()
+
+	if _, err := con.Do("HDEL", s.config.key(registryKey(s.instance.Module)), s.instance.ID); err != nil {
+		s.log().Error().Err(err).Msg("failed to remove instance from registry")
+	}
+}
+
+// heartbeatHandler announces this server instance in the registry
+// until the context is cancelled
+func (s *RedisServer) heartbeatHandler(ctx context.Context) {
+	defer s.unregister()
+
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		s.announce()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// instances loads the instances from the registry hash key. It returns
+// the live instances, and the ids of the stale ones.
+func instances(con redis.Conn, key string) ([]Instance, []string, error) {
+	values, err := redis.StringMap(con.Do("HGETALL", key))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var live []Instance
+	var stale []string
+	for id, data := range values {
+		var instance Instance
+		if err := msgpack.Unmarshal([]byte(data), &instance); err != nil || !instance.alive() {
+			stale = append(stale, id)
+			continue
+		}
+
+		live = append(live, instance)
+	}
+
+	sort.Slice(live, func(i, j int) bool {
+		return live[i].Started.Before(live[j].Started)
+	})
+
+	return live, stale, nil
+}
+
+// Modules lists the names of all modules that have at least one live instance
+func (c *RedisClient) Modules(ctx context.Context) ([]string, error) {
+	con, err := c.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer con.Close()
+
+	names, err := redis.Strings(con.Do("SMEMBERS", c.config.key(registryPrefix)))
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(names)
+	var modules []string
+	for _, module := range names {
+		live, _, err := instances(con, c.config.key(registryKey(module)))
+		if err != nil {
+			return nil, err
+		}
+
+		if len(live) != 0 {
+			modules = append(modules, module)
+		}
+	}
+
+	return modules, nil
+}
+
+// Instances lists all live instances of module
+func (c *RedisClient) Instances(ctx context.Context, module string) ([]Instance, error) {
+	con, err := c.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer con.Close()
+
+	live, _, err := instances(con, c.config.key(registryKey(module)))
+	return live, err
+}
+
+// supports checks if all live instances of module support feature. Older servers
+// don't announce the features they support, so a feature is only used once all
+// instances are known to support it.
+func (c *RedisClient) supports(ctx context.Context, module, feature string) bool {
+	live, err := c.Instances(ctx, module)
+	if err != nil {
+		c.log().Error().Err(err).Msg("failed to check registry")
+		return false
+	}
+
+	for _, instance := range live {
+		if !instance.Supports(feature) {
+			return false
+		}
+	}
+
+	return len(live) != 0
+}
+
+// Lookup returns the live instances of module that serve object. It returns
+// ErrNoServer if no running instance serves this object.
+func (c *RedisClient) Lookup(ctx context.Context, module string, object ObjectID) ([]Instance, error) {
+	instances, err := c.Instances(ctx, module)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []Instance
+	for _, instance := range instances {
+		if instance.Serves(object) {
+			results = append(results, instance)
+		}
+	}
+
+	if len(results) == 0 {
+		return nil, ErrNoServer
+	}
+
+	return results, nil
+}
+
+// escapePattern escapes redis glob special characters
+func escapePattern(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,143 @@
+package zbus
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack"
+)
+
+// registryConn is a connection that serves a registry hash
+type registryConn struct {
+	redis.Conn
+	registry map[string]Instance
+	err      error
+	commands []string
+}
+
+func (c *registryConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	c.commands = append(c.commands, cmd)
+	if cmd != "HGETALL" {
+		return nil, nil
+	}
+
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	var reply []interface{}
+	for id, instance := range c.registry {
+		data, err := msgpack.Marshal(instance)
+		if err != nil {
+			return nil, err
+		}
+		reply = append(reply, []byte(id), data)
+	}
+
+	return reply, nil
+}
+
+func (c *registryConn) Err() error {
+	return nil
+}
+
+func (c *registryConn) Close() error {
+	return nil
+}
+
+func TestInstanceServes(t *testing.T) {
+	instance := Instance{
+		Module:  "module",
+		Objects: []ObjectID{{Name: "calc", Version: "1.0"}},
+	}
+
+	require.True(t, instance.Serves(ObjectID{Name: "calc", Version: "1.0"}))
+	require.True(t, instance.Serves(statusObjectID))
+	require.False(t, instance.Serves(ObjectID{Name: "calc", Version: "2.0"}))
+	require.False(t, instance.Serves(ObjectID{Name: "utils"}))
+}
+
+func TestEscapePattern(t *testing.T) {
+	require.Equal(t, "module", escapePattern("module"))
+	require.Equal(t, `my\*module\?\[x\]`, escapePattern("my*module?[x]"))
+}
+
+func TestRegistryInstances(t *testing.T) {
+	con := &registryConn{registry: map[string]Instance{
+		"live":  {Module: "module", ID: "live", Seen: time.Now()},
+		"stale": {Module: "module", ID: "stale", Seen: time.Now().Add(-time.Minute)},
+	}}
+
+	live, stale, err := instances(con, registryKey("module"))
+	require.NoError(t, err)
+	require.Len(t, live, 1)
+	require.Equal(t, "live", live[0].ID)
+	require.Equal(t, []string{"stale"}, stale)
+}
+
+func TestServed(t *testing.T) {
+	calc := ObjectID{Name: "calc", Version: "1.0"}
+	client := &RedisClient{}
+
+	cases := []struct {
+		name     string
+		registry map[string]Instance
+		err      error
+		served   bool
+	}{
+		{"served", map[string]Instance{"a": {ID: "a", Objects: []ObjectID{calc}, Seen: time.Now()}}, nil, true},
+		{"other object", map[string]Instance{"a": {ID: "a", Objects: []ObjectID{{Name: "utils"}}, Seen: time.Now()}}, nil, false},
+		{"stale", map[string]Instance{"a": {ID: "a", Objects: []ObjectID{calc}, Seen: time.Now().Add(-time.Minute)}}, nil, false},
+		{"empty", nil, nil, false},
+		// the registry can't be checked, keep waiting
+		{"error", nil, fmt.Errorf("connection reset"), true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			con := &registryConn{registry: c.registry, err: c.err}
+			err := client.served(con, "module", calc, "module.calc@1.0", []byte("payload"))
+			if c.served {
+				require.NoError(t, err)
+				require.Equal(t, []string{"HGETALL"}, con.commands)
+			} else {
+				require.Equal(t, ErrNoServer, err)
+				// request is withdrawn from the queue
+				require.Equal(t, []string{"HGETALL", "LREM"}, con.commands)
+			}
+		})
+	}
+}
+
+func TestRegisterAnnounce(t *testing.T) {
+	con := &recordConn{}
+	s := &RedisServer{
+		running:  true,
+		ctx:      context.Background(),
+		instance: Instance{Module: "module", ID: "id"},
+		pool:     &redis.Pool{Dial: func() (redis.Conn, error) { return con, nil }},
+	}
+
+	// announced returns the objects of the last heartbeat
+	announced := func() []ObjectID {
+		var instance Instance
+		for _, cmd := range con.commands {
+			if cmd[0] == "HSET" {
+				require.NoError(t, msgpack.Unmarshal(cmd[3].([]byte), &instance))
+			}
+		}
+		return instance.Objects
+	}
+
+	calc := ObjectID{Name: "calc"}
+	var o T
+	require.NoError(t, s.Register(calc, &o))
+	require.Equal(t, []ObjectID{calc}, announced())
+
+	require.NoError(t, s.Unregister(calc))
+	require.Empty(t, announced())
+}
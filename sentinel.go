@@ -0,0 +1,180 @@
+package zbus
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	// sentinelPort is the default port of sentinels
+	sentinelPort = "26379"
+)
+
+// sentinel discovers the current master of a sentinel group
+type sentinel struct {
+	master string
+	addrs  []string
+	// opts are used to dial the sentinels
+	opts []redis.DialOption
+
+	m sync.Mutex
+}
+
+// address asks the sentinels for the address of the current master. The first
+// sentinel that knows the master is moved to the front so it's asked first next time
+func (s *sentinel) address(opts ...redis.DialOption) (string, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	opts = append(append([]redis.DialOption{}, s.opts...), opts...)
+	var errs []string
+	for i, addr := range s.addrs {
+		host, err := s.ask(addr, opts)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", addr, err))
+			continue
+		}
+
+		copy(s.addrs[1:i+1], s.addrs[:i])
+		s.addrs[0] = addr
+		return host, nil
+	}
+
+	return "", fmt.Errorf("failed to discover master '%s': %s", s.master, strings.Join(errs, ", "))
+}
+
+// ask a single sentinel for the address of the master
+func (s *sentinel) ask(addr string, opts []redis.DialOption) (string, error) {
+	con, err := redis.Dial("tcp", addr, opts...)
+	if err != nil {
+		return "", err
+	}
+	defer con.Close()
+
+	host, err := redis.Strings(con.Do("SENTINEL", "get-master-addr-by-name", s.master))
+	if err == redis.ErrNil {
+		return "", fmt.Errorf("unknown master")
+	} else if err != nil {
+		return "", err
+	}
+
+	if len(host) != 2 {
+		return "", fmt.Errorf("invalid master address '%v'", host)
+	}
+
+	return net.JoinHostPort(host[0], host[1]), nil
+}
+
+// dial connects to the current master. The sentinels are dialed with sentinel options
+// followed by extra, the master with opts
+func (s *sentinel) dial(opts []redis.DialOption, extra ...redis.DialOption) (redis.Conn, error) {
+	host, err := s.address(extra...)
+	if err != nil {
+		return nil, err
+	}
+
+	con, err := redis.Dial("tcp", host, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// the sentinels might not have noticed the failover yet
+	role, err := redis.Values(con.Do("ROLE"))
+	if err != nil {
+		con.Close()
+		return nil, err
+	}
+
+	if len(role) == 0 {
+		con.Close()
+		return nil, fmt.Errorf("invalid role reply from %s", host)
+	}
+
+	if role, _ := redis.String(role[0], nil); role != "master" {
+		con.Close()
+		return nil, fmt.Errorf("%s is not the master of '%s' but a %s", host, s.master, role)
+	}
+
+	return &failoverConn{Conn: con}, nil
+}
+
+// failoverConn is a connection to a master of a sentinel group. Once the master is
+// demoted the connection is marked as broken, so it's not reused by the pool and
+// new connections are made to the new master.
+type failoverConn struct {
+	redis.Conn
+
+	err error
+	m   sync.Mutex
+}
+
+// demoted checks if err is returned because the master is now a replica
+func demoted(err error) bool {
+	e, ok := err.(redis.Error)
+	if !ok {
+		return false
+	}
+
+	// READONLY is returned for writes to a replica, UNBLOCKED for
+	// blocking commands that were waiting when the role changed
+	return strings.HasPrefix(string(e), "READONLY") || strings.HasPrefix(string(e), "UNBLOCKED")
+}
+
+// check marks the connection as broken if the reply (or any of the
+// replies of a pipeline) tells that the master was demoted
+func (c *failoverConn) check(reply interface{}, err error) (interface{}, error) {
+	cause := err
+	if replies, ok := reply.([]interface{}); ok && cause == nil {
+		for _, reply := range replies {
+			if err, ok := reply.(redis.Error); ok && demoted(err) {
+				cause = err
+				break
+			}
+		}
+	}
+
+	if !demoted(cause) {
+		return reply, err
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.err == nil {
+		c.err = fmt.Errorf("master was demoted: %s", cause)
+	}
+
+	return reply, err
+}
+
+func (c *failoverConn) Err() error {
+	c.m.Lock()
+	err := c.err
+	c.m.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	return c.Conn.Err()
+}
+
+func (c *failoverConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	return c.check(c.Conn.Do(cmd, args...))
+}
+
+func (c *failoverConn) Receive() (interface{}, error) {
+	return c.check(c.Conn.Receive())
+}
+
+func (c *failoverConn) DoWithTimeout(timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	return c.check(redis.DoWithTimeout(c.Conn, timeout, cmd, args...))
+}
+
+func (c *failoverConn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	return c.check(redis.ReceiveWithTimeout(c.Conn, timeout))
+}
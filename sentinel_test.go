@@ -0,0 +1,55 @@
+package zbus
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/require"
+)
+
+// replyConn is a connection that returns a fixed reply
+type replyConn struct {
+	redis.Conn
+	reply interface{}
+	err   error
+}
+
+func (c *replyConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	return c.reply, c.err
+}
+
+func (c *replyConn) Err() error {
+	return nil
+}
+
+func TestFailoverConn(t *testing.T) {
+	cases := []struct {
+		name    string
+		reply   interface{}
+		err     error
+		demoted bool
+	}{
+		{"ok", "OK", nil, false},
+		{"error", nil, redis.Error("WRONGTYPE Operation against a key holding the wrong kind of value"), false},
+		{"broken", nil, fmt.Errorf("connection reset"), false},
+		{"readonly", nil, redis.Error("READONLY You can't write against a read only replica."), true},
+		{"unblocked", nil, redis.Error("UNBLOCKED force unblock from blocking operation"), true},
+		{"pipeline", []interface{}{int64(1), redis.Error("READONLY You can't write against a read only replica.")}, nil, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			con := &failoverConn{Conn: &replyConn{reply: c.reply, err: c.err}}
+			reply, err := con.Do("")
+			require.Equal(t, c.reply, reply)
+			require.Equal(t, c.err, err)
+
+			if c.demoted {
+				require.Error(t, con.Err())
+			} else {
+				require.NoError(t, con.Err())
+			}
+		})
+	}
+}
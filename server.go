@@ -4,9 +4,12 @@ import (
 	"context"
 	"fmt"
 	"runtime/debug"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/rs/zerolog"
 	log "github.com/rs/zerolog/log"
 )
 
@@ -20,6 +23,12 @@ var (
 	statusObjectID = ObjectID{Name: "zbus", Version: "1.0"}
 )
 
+const (
+	// streamCheckInterval is how often an idle streaming call checks that
+	// the caller is still there
+	streamCheckInterval = time.Second
+)
+
 const (
 	// WorkerFree free state
 	WorkerFree WorkerState = "free"
@@ -30,9 +39,24 @@ const (
 // Callback defines a callback method signature for responses
 type Callback func(request *Request, response *Response)
 
+// FailureCallback is called by the base server if processing of a request
+// has failed unexpectedly (for example the call paniced)
+type FailureCallback func(request *Request, reason, message string)
+
 // EventCallback is calld by the base server once an event is available
 type EventCallback func(key string, event interface{})
 
+// StreamCallback is called by the base server with each response of a streaming
+// call. It returns an error if the caller is gone, in that case the call is cancelled.
+// It's also called with a nil response while the call is idle, to check that the
+// caller is still there.
+type StreamCallback func(ctx context.Context, request *Request, response *Response) error
+
+// InputCallback is called by the base server to receive the items of the input channel
+// of a call. The returned channel is closed at the end of the input stream. If the input
+// stream fails the callback closes the channel and calls cancel to cancel the call.
+type InputCallback func(ctx context.Context, cancel context.CancelFunc, request *Request) <-chan []byte
+
 // WorkerState represents curret worker state (free, or busy)
 type WorkerState string
 
@@ -44,24 +68,101 @@ type WorkerStatus struct {
 	Action    string      `json:"action,omitempty" yaml:"action,omitempty"`
 }
 
+// StreamStatus reports the events dropped by a stream back pressure policy
+type StreamStatus struct {
+	Name    string `json:"name" yaml:"name"`
+	Dropped uint64 `json:"dropped" yaml:"dropped"`
+}
+
 // Status is returned by the server Status method
 type Status struct {
 	Objects []ObjectID     `json:"objects" yaml:"objects"`
 	Workers []WorkerStatus `json:"workers" yaml:"workers"`
+	Streams []StreamStatus `json:"streams,omitempty" yaml:"streams,omitempty"`
 }
 
 // BaseServer implements the basic server functionality
 // In case you are building your own zbus server
 type BaseServer struct {
-	objects map[ObjectID]*Surrogate
+	// inflight is accessed atomically, keep it first
+	// for 64-bit alignment
+	inflight int64
+
+	objects map[ObjectID]*registeredObject
 	m       sync.RWMutex
 
-	status  []WorkerStatus
+	status  map[uint]WorkerStatus
 	statusM sync.RWMutex
+	// workerID is the id of the last started worker
+	workerID uint
+
+	failed   FailureCallback
+	streamer StreamCallback
+	input    InputCallback
+	blobs    BlobStore
+	shedding LoadShedding
+	logger   *zerolog.Logger
+
+	streams   sync.WaitGroup
+	streaming int64
+	// streamCtx and streamCb are set once the streams are
+	// started, so objects registered later can start their streams
+	streamCtx context.Context
+	streamCb  EventCallback
+	// callCtx is the context of the streaming calls, it's cancelled
+	// separately so in-flight streaming calls can be drained
+	callCtx context.Context
+}
+
+// OnFailure sets the callback to call if processing of a request has failed
+// unexpectedly, for example in case of a panic. Must be set before the workers
+// are started.
+func (s *BaseServer) OnFailure(cb FailureCallback) {
+	s.failed = cb
+}
+
+// OnStreamResponse sets the callback used to deliver the responses of streaming
+// calls. If not set, responses are delivered with the workers callback. Must be
+// set before the workers are started.
+func (s *BaseServer) OnStreamResponse(cb StreamCallback) {
+	s.streamer = cb
+}
+
+// OnStreamInput sets the callback used to receive the items of the input channel of
+// a call. If not set, calls to methods that take an input channel fail. Must be set
+// before the workers are started.
+func (s *BaseServer) OnStreamInput(cb InputCallback) {
+	s.input = cb
+}
+
+// SetLogger sets the logger used by the server instead of the global logger. Must
+// be set before the workers are started.
+func (s *BaseServer) SetLogger(logger zerolog.Logger) {
+	s.logger = &logger
+}
+
+func (s *BaseServer) log() *zerolog.Logger {
+	if s.logger == nil {
+		return &log.Logger
+	}
+
+	return s.logger
+}
+
+// SetBlobStore sets the store of blob arguments and returns. If not set, calls
+// with blob arguments fail, and large returns are sent inline. Must be set
+// before the workers are started.
+func (s *BaseServer) SetBlobStore(store BlobStore) {
+	s.blobs = store
 }
 
 // Register registers an object on server
 func (s *BaseServer) Register(id ObjectID, object interface{}) error {
+	return s.RegisterWith(id, object)
+}
+
+// RegisterWith registers an object on server with options
+func (s *BaseServer) RegisterWith(id ObjectID, object interface{}, opts ...ObjectOption) error {
 	//validate objects methods goes here
 	// wrap object in an abstract wrapper
 	s.m.Lock()
@@ -72,37 +173,142 @@ func (s *BaseServer) Register(id ObjectID, object interface{}) error {
 	}
 
 	if s.objects == nil {
-		s.objects = make(map[ObjectID]*Surrogate)
+		s.objects = make(map[ObjectID]*registeredObject)
 	}
 
 	if _, ok := s.objects[id]; ok {
 		return fmt.Errorf("object already exists")
 	}
 
-	s.objects[id] = NewSurrogate(object)
+	obj := newRegisteredObject(object, opts)
+	s.objects[id] = obj
+	if s.streamCb != nil {
+		s.startStreams(s.streamCtx, id, obj, s.streamCb)
+	}
+
 	return nil
 }
 
-func (s *BaseServer) call(request *Request) (ret Output, err error) {
-	s.m.RLock()
+// Replace atomically replaces the implementation of a registered object. The object
+// keeps its registration options. In-flight calls to the old implementation are
+// allowed to complete, while new calls are served by the new implementation. The
+// object streams are restarted against the new implementation.
+func (s *BaseServer) Replace(id ObjectID, object interface{}) error {
+	s.m.Lock()
+	defer s.m.Unlock()
 
-	surrogate, ok := s.objects[request.Object]
-	s.m.RUnlock()
+	obj, ok := s.objects[id]
+	if !ok {
+		return fmt.Errorf("unknown object")
+	}
+
+	if obj.stop != nil {
+		obj.stop()
+	}
+
+	obj.surrogate = NewSurrogate(object)
+	if s.streamCb != nil {
+		s.startStreams(s.streamCtx, id, obj, s.streamCb)
+	}
+
+	return nil
+}
 
+// Unregister removes an object from the server and stops its streams.
+func (s *BaseServer) Unregister(id ObjectID) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	obj, ok := s.objects[id]
 	if !ok {
-		return ret, fmt.Errorf("unknown object")
+		return fmt.Errorf("unknown object")
 	}
 
-	defer func() {
-		if p := recover(); p != nil {
-			stack := debug.Stack()
-			fmt.Println(string(stack))
-			log.Error().Msg(string(stack))
-			err = fmt.Errorf("remote method call %s.%s() paniced: %s", request.Object, request.Method, p)
+	delete(s.objects, id)
+	if obj.stop != nil {
+		obj.stop()
+	}
+
+	return nil
+}
+
+// surrogate returns the surrogate of the request object
+func (s *BaseServer) surrogate(request *Request) (*Surrogate, error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	obj, ok := s.objects[request.Object]
+	if !ok {
+		return nil, fmt.Errorf("unknown object")
+	}
+
+	// the surrogate can be replaced, but the call
+	// must complete on the one it started with
+	return obj.surrogate, nil
+}
+
+// recovered recovers from a panic in a call of request and sets err
+func (s *BaseServer) recovered(request *Request, err *error) {
+	if p := recover(); p != nil {
+		stack := debug.Stack()
+		fmt.Println(string(stack))
+		s.log().Error().Msg(string(stack))
+		*err = fmt.Errorf("remote method call %s.%s() paniced: %s", request.Object, request.Method, p)
+		if s.failed != nil {
+			s.failed(request, ReasonPanic, fmt.Sprint(p))
 		}
-	}()
+	}
+}
+
+// callOptions returns the call options of request
+func (s *BaseServer) callOptions(ctx context.Context, cancel context.CancelFunc, request *Request) CallOptions {
+	opts := CallOptions{Blobs: s.blobs, Logger: s.log()}
+	if s.input != nil {
+		opts.Input = func() <-chan []byte {
+			return s.input(ctx, cancel, request)
+		}
+	}
+
+	return opts
+}
+
+func (s *BaseServer) call(request *Request) (ret Output, err error) {
+	surrogate, err := s.surrogate(request)
+	if err != nil {
+		return ret, err
+	}
+
+	defer s.recovered(request, &err)
+
+	ctx, cancel := context.WithCancel(withCaller(context.Background(), request.caller))
+	defer cancel()
+
+	return surrogate.CallRequestContext(ctx, request, s.callOptions(ctx, cancel, request))
+}
+
+func (s *BaseServer) callStream(ctx context.Context, cancel context.CancelFunc, request *Request) (items <-chan interface{}, err error) {
+	surrogate, err := s.surrogate(request)
+	if err != nil {
+		return nil, err
+	}
+
+	defer s.recovered(request, &err)
 
-	return surrogate.CallRequest(request)
+	return surrogate.CallStream(ctx, request, s.callOptions(ctx, cancel, request))
+}
+
+// limiter returns the concurrency limiter of the request method
+// or nil if the method is not limited
+func (s *BaseServer) limiter(request *Request) *limiter {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	obj, ok := s.objects[request.Object]
+	if !ok {
+		return nil
+	}
+
+	return obj.limiters[request.Method]
 }
 
 func (s *BaseServer) process(request *Request) *Response {
@@ -136,8 +342,186 @@ func (s *BaseServer) statusOut(id uint) {
 	}
 }
 
+// statusDone removes the status of a stopped worker
+func (s *BaseServer) statusDone(id uint) {
+	s.statusM.Lock()
+	defer s.statusM.Unlock()
+
+	delete(s.status, id)
+}
+
+// processStream starts a streaming call, and acknowledges it once started. The
+// call items are then delivered in the background until the call is done.
+func (s *BaseServer) processStream(request *Request, cb Callback) {
+	s.m.RLock()
+	ctx := s.callCtx
+	if ctx == nil {
+		ctx = s.streamCtx
+	}
+	s.m.RUnlock()
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	ctx, cancel := context.WithCancel(withCaller(ctx, request.caller))
+	items, err := s.callStream(ctx, cancel, request)
+	if err != nil {
+		cancel()
+		cb(request, NewResponse(request.ID, Output{}, err.Error()))
+		return
+	}
+
+	cb(request, NewResponse(request.ID, Output{}, ""))
+
+	s.streams.Add(1)
+	atomic.AddInt64(&s.streaming, 1)
+	go s.streamCall(ctx, cancel, request, items, cb)
+}
+
+// streamCall delivers the items of a streaming call, followed by an end marker
+func (s *BaseServer) streamCall(ctx context.Context, cancel context.CancelFunc, request *Request, items <-chan interface{}, cb Callback) {
+	defer func() {
+		cancel()
+		atomic.AddInt64(&s.streaming, -1)
+		s.streams.Done()
+	}()
+
+	deliver := func(response *Response) error {
+		if s.streamer != nil {
+			return s.streamer(ctx, request, response)
+		} else if response != nil {
+			cb(request, response)
+		}
+
+		return nil
+	}
+
+	ticker := time.NewTicker(streamCheckInterval)
+	defer ticker.Stop()
+
+	var seq uint64
+	for {
+		var response *Response
+		select {
+		case item, ok := <-items:
+			if !ok {
+				var msg string
+				if ctx.Err() != nil {
+					// the call was cut off, the caller must not
+					// take it for a finished stream
+					msg = ErrStreamInterrupted.Error()
+				}
+				response = NewResponse(request.ID, Output{}, msg)
+				response.Seq, response.End = seq+1, true
+				if err := deliver(response); err != nil {
+					s.log().Debug().Err(err).Str("request", request.ID).Msg("failed to end stream")
+				}
+				return
+			}
+
+			data, err := request.codec().Marshal(item)
+			if err != nil {
+				s.log().Error().Err(err).Str("request", request.ID).Msg("failed to encode stream item")
+				continue
+			}
+
+			seq++
+			response = NewResponse(request.ID, Output{Data: data}, "")
+			response.Seq = seq
+		case <-ticker.C:
+		}
+
+		if err := deliver(response); err != nil {
+			s.log().Debug().Err(err).Str("request", request.ID).Msgf("stopping stream %s.%s()", request.Object, request.Method)
+			return
+		}
+	}
+}
+
+// handle processes request, and then all pending requests of the same
+// method if the method has a concurrency limit
+func (s *BaseServer) handle(id uint, request *Request, cb Callback) {
+	err := s.authorize(request)
+	if err == nil {
+		err = s.admit(request)
+	}
+
+	if err != nil {
+		s.log().Debug().Err(err).Str("request", request.ID).Msgf("rejecting request to %s.%s()", request.Object, request.Method)
+		cb(request, NewResponse(request.ID, Output{}, err.Error()))
+		return
+	}
+
+	atomic.AddInt64(&s.inflight, 1)
+	limiter := s.limiter(request)
+	if limiter != nil {
+		ok, err := limiter.acquire(request)
+		if err != nil {
+			atomic.AddInt64(&s.inflight, -1)
+			s.log().Debug().Err(err).Str("request", request.ID).Msgf("rejecting request to %s.%s()", request.Object, request.Method)
+			cb(request, NewResponse(request.ID, Output{}, err.Error()))
+			return
+		} else if !ok {
+			// method is saturated, the request is queued and will be
+			// processed once a running call to the same method is done
+			return
+		}
+	}
+
+	for request != nil {
+		s.statusIn(id, request)
+		if request.Stream {
+			s.processStream(request, cb)
+			s.statusOut(id)
+		} else {
+			response := s.process(request)
+			s.statusOut(id)
+
+			cb(request, response)
+		}
+		atomic.AddInt64(&s.inflight, -1)
+
+		request = nil
+		if limiter != nil {
+			request = s.next(limiter, cb)
+		}
+	}
+}
+
+// next returns the next pending request of limiter that can still be processed.
+// Pending requests that expired are dropped, and the ones that waited longer than
+// the max queue wait are rejected with ErrOverloaded.
+func (s *BaseServer) next(limiter *limiter, cb Callback) *Request {
+	for {
+		request := limiter.release()
+		if request == nil {
+			return nil
+		}
+
+		if request.Expired() {
+			atomic.AddInt64(&s.inflight, -1)
+			s.log().Warn().Str("request", request.ID).Msgf("discarding expired request to %s.%s()", request.Object, request.Method)
+			if s.failed != nil {
+				s.failed(request, ReasonExpired, "")
+			}
+			continue
+		}
+
+		if s.shedding.MaxQueueWait > 0 && request.Waited() > s.shedding.MaxQueueWait {
+			atomic.AddInt64(&s.inflight, -1)
+			s.log().Debug().Err(ErrOverloaded).Str("request", request.ID).Msgf("rejecting request to %s.%s()", request.Object, request.Method)
+			cb(request, NewResponse(request.ID, Output{}, ErrOverloaded.Error()))
+			continue
+		}
+
+		return request
+	}
+}
+
 func (s *BaseServer) worker(ctx context.Context, id uint, wg *sync.WaitGroup, ch <-chan *Request, cb Callback) {
 	defer wg.Done()
+	defer s.statusDone(id)
 	s.statusOut(id)
 
 	for {
@@ -150,36 +534,83 @@ func (s *BaseServer) worker(ctx context.Context, id uint, wg *sync.WaitGroup, ch
 				continue
 			}
 
-			s.statusIn(id, request)
-			response := s.process(request)
-			s.statusOut(id)
-
-			cb(request, response)
+			s.handle(id, request, cb)
 		case <-ctx.Done():
 			return
 		}
 	}
 }
 
-func (s *BaseServer) streamWorker(ctx context.Context, key ObjectID, stream Stream, cb EventCallback) {
+func (s *BaseServer) streamWorker(ctx context.Context, key ObjectID, stream Stream, pressure *BackPressure, cb EventCallback) {
+	defer func() {
+		atomic.AddInt64(&s.streaming, -1)
+		s.streams.Done()
+	}()
+
+	events := stream.Run(ctx)
+	if pressure != nil {
+		events = pressure.pipe(ctx, events)
+	}
+
 	fqn := fmt.Sprintf("%s.%s", key, stream.Name())
-	for event := range stream.Run(ctx) {
+	for event := range events {
 		cb(fqn, event)
 	}
 }
 
 // StartStreams start the stream (events) workers in the background
-// use the ctx to cancel the streams workers
+// use the ctx to cancel the streams workers. Streams of objects registered
+// after this call are started on registration.
 func (s *BaseServer) StartStreams(ctx context.Context, cb EventCallback) {
-	s.m.RLock()
-	defer s.m.RUnlock()
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.streamCtx, s.streamCb = ctx, cb
 	for key, obj := range s.objects {
-		for _, stream := range obj.Streams() {
-			go s.streamWorker(ctx, key, stream, cb)
-		}
+		s.startStreams(ctx, key, obj, cb)
 	}
 }
 
+// startCalls gives the streaming calls their own context, the returned
+// cancel stops all running streaming calls
+func (s *BaseServer) startCalls() context.CancelFunc {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.callCtx = ctx
+	return cancel
+}
+
+// startStreams starts the stream workers of a single object, the streams
+// are stopped if ctx is cancelled or the object is unregistered.
+// must be called with the objects lock held
+func (s *BaseServer) startStreams(ctx context.Context, key ObjectID, obj *registeredObject, cb EventCallback) {
+	ctx, obj.stop = context.WithCancel(ctx)
+	for _, stream := range obj.surrogate.Streams() {
+		s.streams.Add(1)
+		atomic.AddInt64(&s.streaming, 1)
+		go s.streamWorker(ctx, key, stream, obj.pressure[stream.Name()], cb)
+	}
+}
+
+func (s *BaseServer) ids() []ObjectID {
+	ids := make([]ObjectID, 0, len(s.objects))
+	for id := range s.objects {
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+// objectIDs returns the ids of all registered objects
+func (s *BaseServer) objectIDs() []ObjectID {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	return s.ids()
+}
+
 // Status returns a copy of the internal worker status
 func (s *BaseServer) Status() Status {
 	s.statusM.RLock()
@@ -188,33 +619,60 @@ func (s *BaseServer) Status() Status {
 	defer s.m.RUnlock()
 	defer s.statusM.RUnlock()
 
-	ids := make([]ObjectID, 0, len(s.objects))
-	for id := range s.objects {
-		ids = append(ids, id)
+	ids := s.ids()
+
+	workers := make([]uint, 0, len(s.status))
+	for id := range s.status {
+		workers = append(workers, id)
+	}
+	sort.Slice(workers, func(i, j int) bool { return workers[i] < workers[j] })
+
+	results := make([]WorkerStatus, 0, len(workers))
+	for _, id := range workers {
+		results = append(results, s.status[id])
 	}
 
-	results := make([]WorkerStatus, len(s.status))
-	for i := 0; i < len(s.status); i++ {
-		results[i] = s.status[i]
+	var streams []StreamStatus
+	for id, obj := range s.objects {
+		for name, pressure := range obj.pressure {
+			streams = append(streams, StreamStatus{
+				Name:    fmt.Sprintf("%s.%s", id, name),
+				Dropped: pressure.Dropped(),
+			})
+		}
 	}
 
-	return Status{Objects: ids, Workers: results}
+	return Status{Objects: ids, Workers: results, Streams: streams}
 }
 
 // Start starts the workers. Workers will call cb with results of requests. the call will
-// feed requests to workers by feeding requests to channel.
+// feed requests to workers by feeding requests to channel. Start can be called multiple
+// times to start separate pools of workers.
 // panics if workers number is zero.
 func (s *BaseServer) Start(ctx context.Context, wg *sync.WaitGroup, workers uint, cb Callback) chan<- *Request {
 	if workers == 0 {
 		panic("invalid number of workers")
 	}
 
-	s.status = make([]WorkerStatus, workers)
+	// Start can be called multiple times to start multiple
+	// pools of workers, so worker ids are global. Workers
+	// remove their status once they stop.
+	s.statusM.Lock()
+	if s.status == nil {
+		s.status = make(map[uint]WorkerStatus)
+	}
+	base := s.workerID
+	s.workerID += workers
+	for id := base; id < base+workers; id++ {
+		s.status[id] = WorkerStatus{State: WorkerFree, StartTime: time.Now()}
+	}
+	s.statusM.Unlock()
+
 	ch := make(chan *Request)
 	var id uint
 	for ; id < workers; id++ {
 		wg.Add(1)
-		go s.worker(ctx, id, wg, ch, cb)
+		go s.worker(ctx, base+id, wg, ch, cb)
 	}
 
 	return ch
@@ -10,6 +10,7 @@ import (
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestMain(m *testing.M) {
@@ -131,6 +132,189 @@ func TestBaseServerServiceError(t *testing.T) {
 	}
 }
 
+func TestBaseServerPanic(t *testing.T) {
+	s := BaseServer{}
+	var o T
+
+	id := ObjectID{Name: "calc"}
+	s.Register(id, &o)
+
+	var reason, message string
+	s.OnFailure(func(request *Request, r, m string) {
+		reason, message = r, m
+	})
+
+	ctx, shutdown := context.WithCancel(context.Background())
+	defer shutdown()
+	var errorMsg *string
+	cb := func(request *Request, response *Response) {
+		errorMsg = response.Error
+	}
+	var wg sync.WaitGroup
+	feed := s.Start(ctx, &wg, 1, cb)
+
+	request, err := NewRequest("id", "reply-to", id, "Panic")
+	if ok := assert.NoError(t, err); !ok {
+		t.Fatal()
+	}
+
+	select {
+	case feed <- request:
+	case <-time.After(1 * time.Second):
+		t.Fatal("failed to schedule request")
+	}
+
+	shutdown()
+	wg.Wait()
+
+	if ok := assert.NotNil(t, errorMsg); !ok {
+		t.Fatal()
+	}
+
+	assert.Equal(t, "remote method call calc.Panic() paniced: we paniced", *errorMsg)
+	assert.Equal(t, ReasonPanic, reason)
+	assert.Equal(t, "we paniced", message)
+}
+
+type blocker struct {
+	release chan struct{}
+}
+
+func (b *blocker) Block() {
+	<-b.release
+}
+
+func (b *blocker) Echo(s string) string {
+	return s
+}
+
+func TestBaseServerConcurrency(t *testing.T) {
+	s := BaseServer{}
+	o := blocker{release: make(chan struct{})}
+
+	id := ObjectID{Name: "blocker"}
+	s.RegisterWith(id, &o, WithConcurrency("Block", 1))
+
+	ctx, shutdown := context.WithCancel(context.Background())
+	defer shutdown()
+	responses := make(chan *Response, 3)
+	cb := func(request *Request, response *Response) {
+		responses <- response
+	}
+	var wg sync.WaitGroup
+	feed := s.Start(ctx, &wg, 2, cb)
+
+	for _, method := range []string{"Block", "Block", "Echo"} {
+		request, err := NewRequest(method, "reply-to", id, method)
+		if method == "Echo" {
+			request, err = NewRequest(method, "reply-to", id, method, "hello")
+		}
+		if ok := assert.NoError(t, err); !ok {
+			t.Fatal()
+		}
+
+		select {
+		case feed <- request:
+		case <-time.After(1 * time.Second):
+			t.Fatal("failed to schedule request")
+		}
+	}
+
+	// second Block call must not hold the second worker
+	select {
+	case response := <-responses:
+		assert.Equal(t, "Echo", response.ID)
+	case <-time.After(1 * time.Second):
+		t.Fatal("request blocked by a saturated method")
+	}
+
+	close(o.release)
+	for i := 0; i < 2; i++ {
+		select {
+		case response := <-responses:
+			assert.Equal(t, "Block", response.ID)
+		case <-time.After(1 * time.Second):
+			t.Fatal("pending request was not processed")
+		}
+	}
+
+	shutdown()
+	wg.Wait()
+}
+
+func TestBaseServerUnregister(t *testing.T) {
+	s := BaseServer{}
+	var o T
+
+	id := ObjectID{Name: "calc"}
+	require.NoError(t, s.Register(id, &o))
+	require.Error(t, s.Register(id, &o))
+
+	require.NoError(t, s.Unregister(id))
+	require.EqualError(t, s.Unregister(id), "unknown object")
+
+	request, err := NewRequest("id", "reply-to", id, "GetName")
+	require.NoError(t, err)
+
+	response := s.process(request)
+	require.NotNil(t, response.Error)
+	require.Equal(t, "unknown object", *response.Error)
+
+	// can be registered again
+	require.NoError(t, s.Register(id, &o))
+}
+
+func TestBaseServerReplace(t *testing.T) {
+	s := BaseServer{}
+	old := blocker{release: make(chan struct{})}
+
+	id := ObjectID{Name: "blocker"}
+	require.NoError(t, s.Register(id, &old))
+
+	ctx, shutdown := context.WithCancel(context.Background())
+	defer shutdown()
+	responses := make(chan *Response, 2)
+	cb := func(request *Request, response *Response) {
+		responses <- response
+	}
+	var wg sync.WaitGroup
+	feed := s.Start(ctx, &wg, 2, cb)
+
+	block, err := NewRequest("block", "reply-to", id, "Block")
+	require.NoError(t, err)
+	feed <- block
+
+	// replace while a call to the old object is in-flight
+	require.NoError(t, s.Replace(id, &T{Name: "new"}))
+	require.EqualError(t, s.Replace(ObjectID{Name: "unknown"}, &T{}), "unknown object")
+
+	name, err := NewRequest("name", "reply-to", id, "GetName")
+	require.NoError(t, err)
+	feed <- name
+
+	select {
+	case response := <-responses:
+		require.Nil(t, response.Error)
+		var result string
+		require.NoError(t, response.Unmarshal(&Loader{&result}))
+		require.Equal(t, "new", result)
+	case <-time.After(1 * time.Second):
+		t.Fatal("call to replaced object was not processed")
+	}
+
+	close(old.release)
+	select {
+	case response := <-responses:
+		require.Equal(t, "block", response.ID)
+		require.Nil(t, response.Error)
+	case <-time.After(1 * time.Second):
+		t.Fatal("in-flight call did not complete")
+	}
+
+	shutdown()
+	wg.Wait()
+}
+
 func TestBaseServerStream(t *testing.T) {
 	s := BaseServer{}
 	var o T
@@ -164,3 +348,137 @@ func TestBaseServerStream(t *testing.T) {
 		t.Error()
 	}
 }
+
+func TestBaseServerStreamBackPressure(t *testing.T) {
+	s := BaseServer{}
+	var o T
+
+	id := ObjectID{Name: "calc"}
+	policy := BackPressure{Policy: OverflowCoalesce}
+	require.NoError(t, s.RegisterWith(id, &o, WithStreamBackPressure("TikTok", policy)))
+
+	status := s.Status()
+	require.Equal(t, []StreamStatus{{Name: "calc.TikTok"}}, status.Streams)
+}
+
+func TestBaseServerStreamCall(t *testing.T) {
+	s := BaseServer{}
+	var o T
+
+	id := ObjectID{Name: "calc"}
+	require.NoError(t, s.Register(id, &o))
+
+	ctx, shutdown := context.WithCancel(context.Background())
+	defer shutdown()
+
+	responses := make(chan *Response, 10)
+	cb := func(request *Request, response *Response) {
+		responses <- response
+	}
+
+	var wg sync.WaitGroup
+	feed := s.Start(ctx, &wg, 1, cb)
+
+	request, err := NewRequest("id", "reply-to", id, "Count", 2)
+	require.NoError(t, err)
+	request.Stream = true
+
+	feed <- request
+
+	// the call is acknowledged first
+	response := <-responses
+	require.Nil(t, response.Error)
+	require.EqualValues(t, 0, response.Seq)
+
+	for i := 1; i <= 2; i++ {
+		response := <-responses
+		require.EqualValues(t, i, response.Seq)
+		var value int
+		require.NoError(t, response.Unmarshal(&Loader{&value}))
+		require.Equal(t, i, value)
+	}
+
+	response = <-responses
+	require.True(t, response.End)
+	require.EqualValues(t, 3, response.Seq)
+
+	shutdown()
+	wg.Wait()
+	s.streams.Wait()
+}
+
+func TestBaseServerStreamCallInterrupted(t *testing.T) {
+	s := BaseServer{}
+	var o T
+
+	id := ObjectID{Name: "calc"}
+	require.NoError(t, s.Register(id, &o))
+
+	ctx, shutdown := context.WithCancel(context.Background())
+	defer shutdown()
+
+	stopCalls := s.startCalls()
+	defer stopCalls()
+
+	responses := make(chan *Response)
+	cb := func(request *Request, response *Response) {
+		responses <- response
+	}
+
+	var wg sync.WaitGroup
+	feed := s.Start(ctx, &wg, 1, cb)
+
+	request, err := NewRequest("id", "reply-to", id, "Count", 1000)
+	require.NoError(t, err)
+	request.Stream = true
+
+	feed <- request
+
+	response := <-responses
+	require.Nil(t, response.Error)
+
+	// stopping the workers doesn't stop the streaming call
+	shutdown()
+	wg.Wait()
+
+	response = <-responses
+	require.EqualValues(t, 1, response.Seq)
+
+	stopCalls()
+	for response = range responses {
+		if response.End {
+			break
+		}
+	}
+
+	require.NotNil(t, response.Error)
+	require.Equal(t, ErrStreamInterrupted, protocolError(*response.Error))
+	s.streams.Wait()
+}
+
+func TestBaseServerStatusWorkers(t *testing.T) {
+	s := BaseServer{}
+
+	ctx, shutdown := context.WithCancel(context.Background())
+	defer shutdown()
+
+	cb := func(request *Request, response *Response) {}
+
+	var shared, dedicated sync.WaitGroup
+	s.Start(ctx, &shared, 2, cb)
+	feed := s.Start(ctx, &dedicated, 3, cb)
+	require.Len(t, s.Status().Workers, 5)
+
+	// a stopped pool leaves no workers behind
+	close(feed)
+	dedicated.Wait()
+	require.Len(t, s.Status().Workers, 2)
+
+	s.Start(ctx, &dedicated, 3, cb)
+	require.Len(t, s.Status().Workers, 5)
+
+	shutdown()
+	shared.Wait()
+	dedicated.Wait()
+	require.Empty(t, s.Status().Workers)
+}
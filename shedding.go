@@ -0,0 +1,115 @@
+package zbus
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrOverloaded is returned if the server rejected the request because it's overloaded
+	ErrOverloaded = fmt.Errorf("server is overloaded")
+	// ErrRateLimited is returned if the server rejected the request because the caller
+	// exceeded the object or method rate limit
+	ErrRateLimited = fmt.Errorf("rate limit exceeded")
+)
+
+// protocolError converts a protocol error message to an error, well
+// known protocol errors are converted to their typed errors
+func protocolError(msg string) error {
+	for _, err := range []error{ErrOverloaded, ErrRateLimited, ErrForbidden, ErrStreamInterrupted} {
+		if msg == err.Error() {
+			return err
+		}
+	}
+
+	return fmt.Errorf(msg)
+}
+
+// LoadShedding defines when a server starts rejecting requests
+// with ErrOverloaded. Zero values disable the check.
+type LoadShedding struct {
+	// MaxQueueWait is the max time a request can wait in the queue
+	// before it's processed.
+	MaxQueueWait time.Duration
+	// MaxInFlight is the max number of requests that are processed
+	// or waiting for a concurrency limited method.
+	MaxInFlight uint
+}
+
+// bucket is a token bucket rate limiter
+type bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	m      sync.Mutex
+}
+
+func newBucket(rate float64, burst uint) *bucket {
+	if burst == 0 {
+		burst = 1
+	}
+
+	return &bucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// allow takes a token from the bucket if available
+func (b *bucket) allow() bool {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+// SetLoadShedding sets the load shedding policy of the server. Must be set
+// before the workers are started.
+func (s *BaseServer) SetLoadShedding(policy LoadShedding) {
+	s.shedding = policy
+}
+
+// admit checks if request can be processed under the current load
+func (s *BaseServer) admit(request *Request) error {
+	if s.shedding.MaxQueueWait > 0 && request.Waited() > s.shedding.MaxQueueWait {
+		return ErrOverloaded
+	}
+
+	if s.shedding.MaxInFlight > 0 && atomic.LoadInt64(&s.inflight) >= int64(s.shedding.MaxInFlight) {
+		return ErrOverloaded
+	}
+
+	s.m.RLock()
+	obj, ok := s.objects[request.Object]
+	s.m.RUnlock()
+
+	if !ok {
+		return nil
+	}
+
+	// object wide limit is stored under the empty method name
+	for _, name := range []string{"", request.Method} {
+		if rate, ok := obj.rates[name]; ok && !rate.allow() {
+			return ErrRateLimited
+		}
+	}
+
+	return nil
+}
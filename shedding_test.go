@@ -0,0 +1,62 @@
+package zbus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBucket(t *testing.T) {
+	b := newBucket(10, 2)
+
+	require.True(t, b.allow())
+	require.True(t, b.allow())
+	require.False(t, b.allow())
+
+	time.Sleep(150 * time.Millisecond)
+	require.True(t, b.allow())
+}
+
+func TestAdmitRateLimit(t *testing.T) {
+	s := BaseServer{}
+	var o T
+
+	id := ObjectID{Name: "calc"}
+	s.RegisterWith(id, &o, WithRateLimit("Add", 1, 1))
+
+	add, err := NewRequest("id", "reply-to", id, "Add", 1, 2)
+	require.NoError(t, err)
+	name, err := NewRequest("id", "reply-to", id, "GetName")
+	require.NoError(t, err)
+
+	require.NoError(t, s.admit(add))
+	require.Equal(t, ErrRateLimited, s.admit(add))
+	require.NoError(t, s.admit(name))
+}
+
+func TestAdmitLoadShedding(t *testing.T) {
+	s := BaseServer{}
+	var o T
+
+	id := ObjectID{Name: "calc"}
+	s.Register(id, &o)
+	s.SetLoadShedding(LoadShedding{MaxQueueWait: time.Second, MaxInFlight: 1})
+
+	request, err := NewRequest("id", "reply-to", id, "GetName")
+	require.NoError(t, err)
+	require.NoError(t, s.admit(request))
+
+	s.inflight = 1
+	require.Equal(t, ErrOverloaded, s.admit(request))
+	s.inflight = 0
+
+	request.Time = unixMilli(time.Now().Add(-2 * time.Second))
+	require.Equal(t, ErrOverloaded, s.admit(request))
+}
+
+func TestProtocolError(t *testing.T) {
+	require.Equal(t, ErrOverloaded, protocolError(ErrOverloaded.Error()))
+	require.Equal(t, ErrRateLimited, protocolError(ErrRateLimited.Error()))
+	require.EqualError(t, protocolError("unknown object"), "unknown object")
+}
@@ -0,0 +1,131 @@
+package zbus
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultDrainTimeout = 30 * time.Second
+)
+
+// DrainError is returned by the server Run method if the
+// graceful shutdown did not complete in time.
+type DrainError struct {
+	// Err is the reason of the shutdown
+	Err error
+	// Calls are the in-flight calls that did not complete
+	Calls []string
+	// Streams is the number of streams that did not stop
+	Streams int
+}
+
+func (e *DrainError) Error() string {
+	return fmt.Sprintf(
+		"shutdown abandoned %d in-flight call(s) [%s] and %d stream(s)",
+		len(e.Calls), strings.Join(e.Calls, ", "), e.Streams,
+	)
+}
+
+// Unwrap returns the shutdown reason
+func (e *DrainError) Unwrap() error {
+	return e.Err
+}
+
+// takePending removes all requests that are waiting for a concurrency
+// limited method and returns them.
+func (s *BaseServer) takePending() []*Request {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	var pending []*Request
+	for _, obj := range s.objects {
+		for _, limiter := range obj.limiters {
+			pending = append(pending, limiter.take()...)
+		}
+	}
+
+	atomic.AddInt64(&s.inflight, -int64(len(pending)))
+	return pending
+}
+
+// busy returns the actions of all busy workers
+func (s *BaseServer) busy() []string {
+	s.statusM.RLock()
+	defer s.statusM.RUnlock()
+
+	var actions []string
+	for _, status := range s.status {
+		if status.State == WorkerBusy {
+			actions = append(actions, status.Action)
+		}
+	}
+
+	return actions
+}
+
+// requeue pushes requests back to the head of their queues
+// so they can be served by another instance (or after restart)
+func (s *RedisServer) requeue(requests []*Request) {
+	if len(requests) == 0 {
+		return
+	}
+
+	con := s.pool.Get()
+	defer con.Close()
+
+	for _, request := range requests {
+		payload, err := request.Encode()
+		if err != nil {
+			s.log().Error().Err(err).Str("request", request.ID).Msg("failed to encode request")
+			continue
+		}
+
+		if len(request.Signature) != 0 {
+			// the request was not served, so it's not a replay
+			con.Send("DEL", s.config.key(seenKey(request.ID)))
+		}
+		con.Send("LPUSH", s.config.key(queueName(s.module, request.Object, request.Priority.orNormal())), payload)
+	}
+
+	if _, err := con.Do(""); err != nil {
+		s.log().Error().Err(err).Msg("failed to requeue pending requests")
+	}
+}
+
+// drain gracefully stops the server. The feeders are woken up to stop pulling new requests,
+// requests that were not started yet are requeued and then it waits for in-flight calls
+// and streams to finish until the drain timeout. Streaming calls that did not finish by
+// then are stopped with stopCalls. drained is closed once all calls and streams are done,
+// which can be after drain returns if the timeout is reached.
+func (s *RedisServer) drain(shutdown, stopCalls context.CancelFunc, drained chan struct{}) (calls []string, streams int) {
+	timeout := s.config.drain
+	if timeout == 0 {
+		timeout = defaultDrainTimeout
+	}
+	deadline := time.After(timeout)
+
+	s.wake()
+
+	go func() {
+		defer close(drained)
+		s.feedersWg.Wait()
+		s.requeue(s.takePending())
+		// workers exit once they are free
+		shutdown()
+		s.workersWg.Wait()
+		s.streams.Wait()
+	}()
+
+	defer stopCalls()
+
+	select {
+	case <-drained:
+		return nil, 0
+	case <-deadline:
+		return s.busy(), int(atomic.LoadInt64(&s.streaming))
+	}
+}
@@ -0,0 +1,72 @@
+package zbus
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTakePending(t *testing.T) {
+	s := BaseServer{}
+	var o T
+
+	id := ObjectID{Name: "calc"}
+	s.RegisterWith(id, &o, WithConcurrency("Add", 1))
+
+	first, err := NewRequest("first", "reply-to", id, "Add", 1, 2)
+	require.NoError(t, err)
+	second, err := NewRequest("second", "reply-to", id, "Add", 1, 2)
+	require.NoError(t, err)
+
+	limiter := s.limiter(first)
+	require.NotNil(t, limiter)
+	ok, err := limiter.acquire(first)
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = limiter.acquire(second)
+	require.NoError(t, err)
+	require.False(t, ok)
+	s.inflight = 2
+
+	pending := s.takePending()
+	require.Equal(t, []*Request{second}, pending)
+	require.EqualValues(t, 1, s.inflight)
+
+	// nothing is left to process after the running call is done
+	require.Nil(t, limiter.release())
+}
+
+func TestDrainError(t *testing.T) {
+	err := &DrainError{Err: context.Canceled, Calls: []string{"[calc].Add()"}, Streams: 1}
+	require.EqualError(t, err, "shutdown abandoned 1 in-flight call(s) [[calc].Add()] and 1 stream(s)")
+	require.Equal(t, context.Canceled, err.Unwrap())
+}
+
+func TestRegisterStopping(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &RedisServer{
+		running: true,
+		ctx:     ctx,
+		pool:    &redis.Pool{Dial: func() (redis.Conn, error) { return &recordConn{}, nil }},
+	}
+
+	var o T
+	require.NoError(t, s.Register(ObjectID{Name: "calc"}, &o))
+
+	// pools can't be started once the server is shutting down
+	cancel()
+	require.Error(t, s.Register(ObjectID{Name: "utils"}, &o))
+
+	s.stopping = true
+	require.Error(t, s.Register(ObjectID{Name: "utils"}, &o))
+}
+
+func TestRunStopping(t *testing.T) {
+	// the last run abandoned calls that are still running
+	s := &RedisServer{drained: make(chan struct{})}
+	err := s.Run(context.Background())
+	require.EqualError(t, err, "server is still stopping")
+	require.False(t, s.running)
+}
@@ -0,0 +1,266 @@
+package zbus
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"sort"
+	"sync"
+	"time"
+)
+
+// replayWindow is how old a signed request can be before it's rejected.
+// The ids of served signed requests are remembered for twice as long, to
+// also cover requests sent by callers with clocks that are ahead.
+const replayWindow = 5 * time.Minute
+
+var (
+	// ErrUnauthenticated is returned if a message signature is missing or invalid
+	ErrUnauthenticated = fmt.Errorf("message signature is missing or invalid")
+
+	// errReplayed is returned if a signed request was already served, or is
+	// older than the replay window
+	errReplayed = fmt.Errorf("request was replayed or is too old")
+)
+
+// seenKey is the key that marks signed request id as served
+func seenKey(id string) string {
+	return fmt.Sprintf("zbus.seen.%s", id)
+}
+
+// Keyring holds the keys used to sign and verify messages. Messages are signed
+// with the current key, and verified with any key in the keyring. Keys are
+// rotated by adding the new key to all peers, then making it the current key,
+// then removing the old key.
+type Keyring struct {
+	current string
+	keys    map[string][]byte
+	m       sync.RWMutex
+}
+
+// NewKeyring creates a keyring that signs messages with key
+func NewKeyring(id string, key []byte) *Keyring {
+	return &Keyring{
+		current: id,
+		keys:    map[string][]byte{id: key},
+	}
+}
+
+// Add adds a key that is only used to verify messages
+func (k *Keyring) Add(id string, key []byte) {
+	k.m.Lock()
+	defer k.m.Unlock()
+
+	k.keys[id] = key
+}
+
+// Rotate makes key the signing key. The previous keys are still used to
+// verify messages until they are removed.
+func (k *Keyring) Rotate(id string, key []byte) {
+	k.m.Lock()
+	defer k.m.Unlock()
+
+	k.keys[id] = key
+	k.current = id
+}
+
+// Remove removes a key, the signing key can't be removed
+func (k *Keyring) Remove(id string) error {
+	k.m.Lock()
+	defer k.m.Unlock()
+
+	if id == k.current {
+		return fmt.Errorf("can't remove the signing key")
+	}
+
+	delete(k.keys, id)
+	return nil
+}
+
+// signer returns the current key id, and a mac with the current key
+func (k *Keyring) signer() (string, hash.Hash) {
+	k.m.RLock()
+	defer k.m.RUnlock()
+
+	return k.current, hmac.New(sha256.New, k.keys[k.current])
+}
+
+// verifier returns a mac with key id
+func (k *Keyring) verifier(id string) (hash.Hash, bool) {
+	k.m.RLock()
+	defer k.m.RUnlock()
+
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, false
+	}
+
+	return hmac.New(sha256.New, key), true
+}
+
+// digest writes the signed fields of a message to a mac, each field
+// is prefixed with its length so the digest is not ambiguous
+type digest struct {
+	hash.Hash
+}
+
+func (d digest) bytes(data []byte) {
+	d.uint(uint64(len(data)))
+	d.Write(data)
+}
+
+func (d digest) string(s string) {
+	d.bytes([]byte(s))
+}
+
+func (d digest) uint(v uint64) {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	d.Write(buf[:])
+}
+
+func (d digest) bool(v bool) {
+	if v {
+		d.uint(1)
+	} else {
+		d.uint(0)
+	}
+}
+
+func (d digest) blobs(blobs map[int]Blob) {
+	indexes := make([]int, 0, len(blobs))
+	for i := range blobs {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	d.uint(uint64(len(indexes)))
+	for _, i := range indexes {
+		blob := blobs[i]
+		d.uint(uint64(i))
+		d.string(blob.ID)
+		d.uint(uint64(blob.Size))
+		d.uint(uint64(blob.Chunks))
+		d.bytes(blob.Hash)
+	}
+}
+
+// sign signs a message with the current key, write writes the message
+// fields to the digest
+func sign(keyring *Keyring, write func(d digest)) (string, []byte) {
+	id, mac := keyring.signer()
+	write(digest{mac})
+	return id, mac.Sum(nil)
+}
+
+// verify verifies the signature of a message
+func verify(keyring *Keyring, id string, signature []byte, write func(d digest)) error {
+	mac, ok := keyring.verifier(id)
+	if !ok {
+		return ErrUnauthenticated
+	}
+
+	write(digest{mac})
+	if !hmac.Equal(mac.Sum(nil), signature) {
+		return ErrUnauthenticated
+	}
+
+	return nil
+}
+
+// digest writes the signed request fields. Compression is not part of
+// the signature, requests are signed before they are compressed. The
+// accepted response compression is signed, it's set before signing.
+func (m *Request) digest(d digest) {
+	d.string(m.ID)
+	d.uint(uint64(len(m.Inputs)))
+	for _, input := range m.Inputs {
+		d.bytes(input)
+	}
+	d.string(m.Object.Name)
+	d.string(string(m.Object.Version))
+	d.string(m.ReplyTo)
+	d.string(m.Method)
+	d.uint(uint64(m.Expires))
+	d.uint(uint64(m.Time))
+	d.bool(m.Stream)
+	d.blobs(m.Blobs)
+	d.string(m.ContentType)
+	d.string(m.AcceptCompression)
+	d.bool(m.AcceptBlobs)
+	d.string(string(m.Priority))
+}
+
+// sign signs the request if keyring is set
+func (m *Request) sign(keyring *Keyring) {
+	if keyring == nil {
+		return
+	}
+
+	m.KeyID, m.Signature = sign(keyring, m.digest)
+}
+
+// Verify verifies the request signature, and returns ErrUnauthenticated if
+// the request is not signed by a key of keyring
+func (m *Request) Verify(keyring *Keyring) error {
+	return verify(keyring, m.KeyID, m.Signature, m.digest)
+}
+
+// digest writes the signed response fields
+func (m *Response) digest(d digest) {
+	d.string(m.ID)
+	d.bytes(m.Output.Data)
+	d.bool(m.Output.Error != nil)
+	if m.Output.Error != nil {
+		d.string(m.Output.Error.Message)
+	}
+	d.blobs(m.Output.Blobs)
+	d.bool(m.Error != nil)
+	if m.Error != nil {
+		d.string(*m.Error)
+	}
+	d.uint(m.Seq)
+	d.bool(m.End)
+	d.string(m.ContentType)
+}
+
+// sign signs the response if keyring is set
+func (m *Response) sign(keyring *Keyring) {
+	if keyring == nil {
+		return
+	}
+
+	m.KeyID, m.Signature = sign(keyring, m.digest)
+}
+
+// Verify verifies the response signature, and returns ErrUnauthenticated if
+// the response is not signed by a key of keyring
+func (m *Response) Verify(keyring *Keyring) error {
+	return verify(keyring, m.KeyID, m.Signature, m.digest)
+}
+
+// sign signs the input item of request id if keyring is set
+func (i *StreamItem) sign(keyring *Keyring, id string) {
+	if keyring == nil {
+		return
+	}
+
+	i.KeyID, i.Signature = sign(keyring, func(d digest) {
+		d.string(id)
+		d.uint(i.Seq)
+		d.bytes(i.Data)
+		d.bool(i.End)
+	})
+}
+
+// Verify verifies the signature of an input item of request id
+func (i *StreamItem) Verify(keyring *Keyring, id string) error {
+	return verify(keyring, i.KeyID, i.Signature, func(d digest) {
+		d.string(id)
+		d.uint(i.Seq)
+		d.bytes(i.Data)
+		d.bool(i.End)
+	})
+}
@@ -0,0 +1,184 @@
+package zbus
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/require"
+)
+
+// replayConn serves SET NX on a set of keys
+type replayConn struct {
+	redis.Conn
+	seen map[string]bool
+}
+
+func (c *replayConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cmd != "SET" {
+		return nil, nil
+	}
+
+	key := args[0].(string)
+	if c.seen[key] {
+		return nil, nil
+	}
+
+	c.seen[key] = true
+	return "OK", nil
+}
+
+func (c *replayConn) Err() error {
+	return nil
+}
+
+func (c *replayConn) Close() error {
+	return nil
+}
+
+func TestSignRequest(t *testing.T) {
+	keyring := NewKeyring("k1", []byte("secret"))
+
+	for _, codec := range []Codec{MsgPack, JSON, CBOR} {
+		request, err := NewRequestWithCodec(codec, "id", "reply-to", ObjectID{Name: "calc"}, "Join", " ", strings.Repeat("zbus", 1024))
+		require.NoError(t, err)
+		require.Equal(t, ErrUnauthenticated, request.Verify(keyring))
+
+		// requests are signed before they are compressed, but after
+		// the accepted compression is set
+		request.accept(1024)
+		request.sign(keyring)
+		require.Equal(t, "k1", request.KeyID)
+		require.NoError(t, request.compress(1024))
+
+		data, err := request.Encode()
+		require.NoError(t, err)
+
+		loaded, err := LoadRequest(data)
+		require.NoError(t, err)
+		require.NoError(t, loaded.Verify(keyring))
+
+		// unknown key
+		require.Equal(t, ErrUnauthenticated, loaded.Verify(NewKeyring("k2", []byte("secret"))))
+		require.Equal(t, ErrUnauthenticated, loaded.Verify(NewKeyring("k1", []byte("other"))))
+
+		loaded.Method = "Concat"
+		require.Equal(t, ErrUnauthenticated, loaded.Verify(keyring))
+	}
+}
+
+func TestSignRequestFields(t *testing.T) {
+	keyring := NewKeyring("k1", []byte("secret"))
+
+	request, err := NewRequest("id", "reply-to", ObjectID{Name: "calc"}, "Logs", "container")
+	require.NoError(t, err)
+	request.Stream = true
+	request.SetExpiry(time.Now().Add(time.Minute))
+	request.sign(keyring)
+
+	data, err := request.Encode()
+	require.NoError(t, err)
+
+	loaded, err := LoadRequest(data)
+	require.NoError(t, err)
+	require.True(t, loaded.Stream)
+	require.NotZero(t, loaded.Expires)
+	require.NoError(t, loaded.Verify(keyring))
+
+	loaded.Expires = 0
+	require.Equal(t, ErrUnauthenticated, loaded.Verify(keyring))
+	loaded.Expires, loaded.Stream = request.Expires, false
+	require.Equal(t, ErrUnauthenticated, loaded.Verify(keyring))
+
+	// the negotiated fields can't be changed on the way either
+	for _, tamper := range []func(r *Request){
+		func(r *Request) { r.Priority = PriorityHigh },
+		func(r *Request) { r.AcceptBlobs = true },
+		func(r *Request) { r.AcceptCompression = CompressionGzip },
+	} {
+		loaded, err := LoadRequest(data)
+		require.NoError(t, err)
+		tamper(loaded)
+		require.Equal(t, ErrUnauthenticated, loaded.Verify(keyring))
+	}
+}
+
+func TestFreshRequest(t *testing.T) {
+	con := &replayConn{seen: make(map[string]bool)}
+	server := &RedisServer{
+		pool:   &redis.Pool{Dial: func() (redis.Conn, error) { return con, nil }},
+		config: newConfig([]Option{WithNamespace("ns")}),
+	}
+
+	request, err := NewRequest("id", "reply-to", ObjectID{Name: "calc"}, "Add", 1, 2)
+	require.NoError(t, err)
+
+	require.NoError(t, server.fresh(request))
+	require.True(t, con.seen["ns.zbus.seen.id"])
+
+	// the same request can't be served twice
+	require.Equal(t, errReplayed, server.fresh(request))
+
+	old, err := NewRequest("old", "reply-to", ObjectID{Name: "calc"}, "Add", 1, 2)
+	require.NoError(t, err)
+	old.Time = unixMilli(time.Now().Add(-2 * replayWindow))
+	require.Equal(t, errReplayed, server.fresh(old))
+	require.False(t, con.seen["ns.zbus.seen.old"])
+}
+
+func TestSignResponse(t *testing.T) {
+	keyring := NewKeyring("k1", []byte("secret"))
+
+	output, err := returnFromObjects(MsgPack, nil, strings.Repeat("zbus", 1024))
+	require.NoError(t, err)
+
+	request, err := NewRequest("id", "reply-to", ObjectID{Name: "calc"}, "Join")
+	require.NoError(t, err)
+	request.AcceptCompression = CompressionGzip
+
+	response := NewResponse("id", output, "")
+	response.sign(keyring)
+	require.NoError(t, response.compress(request, 1024))
+
+	data, err := response.Encode()
+	require.NoError(t, err)
+
+	loaded, err := LoadResponse(data)
+	require.NoError(t, err)
+	require.NoError(t, loaded.Verify(keyring))
+
+	loaded.Output.Data = []byte("spoofed")
+	require.Equal(t, ErrUnauthenticated, loaded.Verify(keyring))
+}
+
+func TestKeyringRotate(t *testing.T) {
+	old := NewKeyring("k1", []byte("old"))
+	keyring := NewKeyring("k1", []byte("old"))
+
+	request, err := NewRequest("id", "reply-to", ObjectID{Name: "calc"}, "Join")
+	require.NoError(t, err)
+	request.sign(old)
+
+	keyring.Rotate("k2", []byte("new"))
+	require.NoError(t, request.Verify(keyring))
+
+	request.sign(keyring)
+	require.Equal(t, "k2", request.KeyID)
+	require.NoError(t, request.Verify(keyring))
+
+	require.Error(t, keyring.Remove("k2"))
+	require.NoError(t, keyring.Remove("k1"))
+
+	request.sign(old)
+	require.Equal(t, ErrUnauthenticated, request.Verify(keyring))
+}
+
+func TestSignStreamItem(t *testing.T) {
+	keyring := NewKeyring("k1", []byte("secret"))
+
+	item := StreamItem{Seq: 1, Data: []byte("data")}
+	item.sign(keyring, "id")
+	require.NoError(t, item.Verify(keyring, "id"))
+	require.Equal(t, ErrUnauthenticated, item.Verify(keyring, "other"))
+}
@@ -0,0 +1,450 @@
+package zbus
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/google/uuid"
+)
+
+const (
+	// streamWindow is the max number of responses of a streaming call that
+	// are not received by the caller yet, before the server waits for the
+	// caller to catch up
+	streamWindow = 128
+)
+
+var (
+	// ErrStreamInterrupted is sent with the end marker of a streaming call
+	// that was cut off before it finished, for example by a server shutdown
+	ErrStreamInterrupted = fmt.Errorf("stream interrupted")
+	// errStreamCancelled is returned by the stream callback if the caller
+	// has cancelled the call
+	errStreamCancelled = fmt.Errorf("stream cancelled by caller")
+)
+
+// cancelKey is set by the caller of a streaming call to cancel the call
+func cancelKey(replyTo string) string {
+	return fmt.Sprintf("%s.cancel", replyTo)
+}
+
+// inputKey is the queue where the caller pushes the items of the input channel of a call
+func inputKey(replyTo string) string {
+	return fmt.Sprintf("%s.in", replyTo)
+}
+
+// icb input callback, receives the items of the input channel of a call
+func (s *RedisServer) icb(ctx context.Context, cancel context.CancelFunc, request *Request) <-chan []byte {
+	ch := make(chan []byte)
+	key := inputKey(request.ReplyTo)
+
+	go func() {
+		defer close(ch)
+
+		con := s.pool.Get()
+		defer con.Close()
+		defer con.Do("DEL", key)
+
+		var seq uint64
+		received := time.Now()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+
+			payload, err := redis.ByteSlices(block(con, time.Second, "BLPOP", key, 1))
+			if err == redis.ErrNil {
+				if time.Since(received) > time.Duration(s.config.responseTTL())*time.Second {
+					s.log().Error().Str("request", request.ID).Msg("timed out waiting for input")
+					cancel()
+					return
+				}
+
+				if cancelled, _ := redis.Bool(con.Do("EXISTS", cancelKey(request.ReplyTo))); cancelled {
+					cancel()
+					return
+				}
+				continue
+			} else if err != nil {
+				s.log().Error().Err(err).Str("request", request.ID).Msg("failed to receive input")
+				cancel()
+				return
+			}
+
+			received = time.Now()
+			item, err := LoadStreamItem(payload[1])
+			if err != nil {
+				s.log().Error().Err(err).Str("request", request.ID).Msg("failed to load input item")
+				cancel()
+				return
+			}
+
+			if s.config.keyring != nil {
+				if err := item.Verify(s.config.keyring, request.ID); err != nil {
+					s.log().Error().Str("request", request.ID).Msg("input item is not authenticated")
+					cancel()
+					return
+				}
+			}
+
+			if seq++; item.Seq != seq {
+				s.log().Error().Str("request", request.ID).Msgf("input is out of sequence, expected %d got %d", seq, item.Seq)
+				cancel()
+				return
+			}
+
+			if item.End {
+				return
+			}
+
+			select {
+			case ch <- item.Data:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch
+}
+
+// scb stream callback, pushes the response of a streaming call. It waits if the
+// caller is not keeping up with the stream.
+func (s *RedisServer) scb(ctx context.Context, request *Request, response *Response) error {
+	con := s.pool.Get()
+	defer con.Close()
+
+	if response != nil {
+		response.ContentType = request.ContentType
+		response.sign(s.config.keyring)
+		if err := response.compress(request, s.config.compression); err != nil {
+			return err
+		}
+
+		payload, err := response.Encode()
+		if err != nil {
+			return err
+		}
+
+		con.Send("RPUSH", request.ReplyTo, payload)
+		con.Send("EXPIRE", request.ReplyTo, s.config.responseTTL())
+	}
+
+	deadline := time.Now().Add(time.Duration(s.config.responseTTL()) * time.Second)
+	for {
+		con.Send("LLEN", request.ReplyTo)
+		con.Send("EXISTS", cancelKey(request.ReplyTo))
+		replies, err := redis.Values(con.Do(""))
+		if err != nil {
+			return err
+		}
+
+		length, _ := redis.Int(replies[len(replies)-2], nil)
+		cancelled, _ := redis.Bool(replies[len(replies)-1], nil)
+		if cancelled {
+			return errStreamCancelled
+		}
+
+		if response == nil || response.End || length < streamWindow {
+			return nil
+		}
+
+		// caller is not keeping up, wait for it to catch up
+		if time.Now().After(deadline) {
+			return fmt.Errorf("caller is not receiving the stream")
+		}
+
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// CallStream is a running streaming call
+type CallStream struct {
+	// Events receives the items sent by the method. It's closed once the
+	// call is done, Err then tells if the call finished or was cut off.
+	Events <-chan Event
+
+	err error
+}
+
+// Err returns the error that ended the stream, or nil if the method closed its
+// channel. It must be called once Events is closed.
+func (s *CallStream) Err() error {
+	return s.err
+}
+
+// RequestStream makes a request to a streaming method of object. A streaming method takes
+// a context.Context as its first argument, and returns a single channel. The items sent by
+// the method are delivered on the returned stream, which is closed once the method closes
+// its channel. Cancelling ctx cancels the call on the server. Like RequestContext, a channel argument
+// is streamed to the input channel of the method, which allows bidirectional streaming calls.
+func (c *RedisClient) RequestStream(ctx context.Context, module string, object ObjectID, method string, args ...interface{}) (*CallStream, error) {
+	args, in := input(args)
+	args, blobs, err := c.upload(ctx, module, args)
+	if err != nil {
+		return nil, err
+	}
+
+	id := uuid.New().String()
+	replyTo := c.config.key(id)
+	request, err := NewRequestWithCodec(c.config.encoding(), id, replyTo, object, method, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	request.Blobs = blobs
+	request.Stream = true
+	request.Priority = c.priority(ctx, module)
+	if expiry, ok := c.expiry(ctx); ok {
+		request.SetExpiry(expiry)
+	}
+
+	// signing must come after all signed fields are set
+	request.accept(c.config.compression)
+	request.sign(c.config.keyring)
+	if err := c.compress(ctx, module, request); err != nil {
+		return nil, err
+	}
+
+	payload, err := request.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	con, err := c.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var check <-chan time.Time
+	if c.config.failFast > 0 {
+		if !c.serving(con, module, object) {
+			con.Close()
+			return nil, ErrNoServer
+		}
+
+		ticker := time.NewTicker(c.config.failFast)
+		defer ticker.Stop()
+		check = ticker.C
+	}
+
+	queue := c.config.key(queueName(module, object, request.Priority))
+	if err := con.Send("RPUSH", queue, payload); err != nil {
+		con.Close()
+		return nil, err
+	}
+
+	// stop sending input once the call is done
+	ctx, stop := context.WithCancel(ctx)
+	if in.IsValid() {
+		go c.send(ctx, id, replyTo, in)
+	}
+
+	// wait for the call to start
+	for {
+		_, err := c.getResponse(con, replyTo)
+		if err == redis.ErrNil {
+			select {
+			case <-ctx.Done():
+				stop()
+				c.cancelStream(con, replyTo)
+				con.Close()
+				return nil, ctx.Err()
+			case <-check:
+				if err := c.served(con, module, object, queue, payload); err != nil {
+					stop()
+					con.Close()
+					return nil, err
+				}
+			default:
+			}
+			continue
+		} else if err != nil && con.Err() != nil {
+			if con, err = c.reconnect(ctx, con); err != nil {
+				stop()
+				return nil, err
+			}
+			continue
+		} else if err != nil {
+			stop()
+			con.Close()
+			return nil, err
+		}
+
+		break
+	}
+
+	ch := make(chan Event)
+	stream := &CallStream{Events: ch}
+	go func() {
+		// the error is set before the channel is closed
+		defer close(ch)
+		defer func() {
+			con.Close()
+		}()
+		defer stop()
+
+		stream.err = c.receive(ctx, id, replyTo, &con, ch)
+		if stream.err != nil {
+			c.log().Debug().Err(stream.err).Str("request", id).Msg("stream did not finish")
+		}
+	}()
+
+	return stream, nil
+}
+
+// receive delivers the items of the streaming call id to ch until the end of the
+// stream. It returns the error that ended the stream early, the call is then
+// cancelled on the server.
+func (c *RedisClient) receive(ctx context.Context, id, replyTo string, con *redis.Conn, ch chan<- Event) error {
+	var seq uint64
+	for {
+		response, err := c.getResponse(*con, replyTo)
+		if err == redis.ErrNil {
+			select {
+			case <-ctx.Done():
+				c.cancelStream(*con, replyTo)
+				return ctx.Err()
+			default:
+			}
+			continue
+		} else if err != nil && (*con).Err() != nil {
+			if *con, err = c.reconnect(ctx, *con); err != nil {
+				// con is already closed, cancel with a new connection
+				*con = c.pool.Get()
+				c.cancelStream(*con, replyTo)
+				return err
+			}
+			continue
+		} else if err != nil {
+			c.cancelStream(*con, replyTo)
+			return err
+		}
+
+		if seq++; response.Seq != seq {
+			c.cancelStream(*con, replyTo)
+			return fmt.Errorf("stream is out of sequence, expected %d got %d", seq, response.Seq)
+		}
+
+		if response.End {
+			if response.Error != nil {
+				return protocolError(*response.Error)
+			}
+			return nil
+		}
+
+		select {
+		case ch <- Event(response.Output.Data):
+		case <-ctx.Done():
+			c.cancelStream(*con, replyTo)
+			return ctx.Err()
+		}
+	}
+}
+
+// input replaces the input channel argument in args (if any) with a placeholder. It
+// returns the new arguments, and the input channel.
+func input(args []interface{}) ([]interface{}, reflect.Value) {
+	for i, arg := range args {
+		if arg == nil {
+			continue
+		}
+
+		value := reflect.ValueOf(arg)
+		if !isInput(value.Type()) {
+			continue
+		}
+
+		// the caller arguments must not be modified
+		args = append([]interface{}(nil), args...)
+		args[i] = nil
+		return args, value
+	}
+
+	return args, reflect.Value{}
+}
+
+// send pushes the items received from ch to the input queue of the call id, until ch is
+// closed or ctx is cancelled. It waits if the server is not keeping up with the stream.
+func (c *RedisClient) send(ctx context.Context, id, replyTo string, ch reflect.Value) {
+	con := c.pool.Get()
+	defer con.Close()
+
+	key := inputKey(replyTo)
+	cases := []reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: ch},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+	}
+
+	var seq uint64
+	for {
+		chosen, value, ok := reflect.Select(cases)
+		if chosen == 1 {
+			return
+		}
+
+		item := StreamItem{Seq: seq + 1, End: !ok}
+		if ok {
+			data, err := c.config.encoding().Marshal(value.Interface())
+			if err != nil {
+				c.log().Error().Err(err).Str("request", id).Msg("failed to encode input item")
+				continue
+			}
+
+			item.Data = data
+		}
+
+		item.sign(c.config.keyring, id)
+		payload, err := item.Encode()
+		if err != nil {
+			c.log().Error().Err(err).Str("request", id).Msg("failed to encode input item")
+			continue
+		}
+
+		seq++
+		con.Send("RPUSH", key, payload)
+		con.Send("EXPIRE", key, c.config.responseTTL())
+		replies, err := redis.Values(con.Do(""))
+		if err != nil {
+			c.log().Error().Err(err).Str("request", id).Msg("failed to send input")
+			return
+		}
+
+		if item.End {
+			return
+		}
+
+		// server is not keeping up, wait for it to catch up
+		for length, _ := redis.Int(replies[0], nil); length >= streamWindow; {
+			select {
+			case <-time.After(100 * time.Millisecond):
+			case <-ctx.Done():
+				return
+			}
+
+			if length, err = redis.Int(con.Do("LLEN", key)); err != nil {
+				c.log().Error().Err(err).Str("request", id).Msg("failed to send input")
+				return
+			}
+		}
+	}
+}
+
+// cancelStream asks the server to stop the streaming call that replies
+// to replyTo, and drops the responses that are not received yet
+func (c *RedisClient) cancelStream(con redis.Conn, replyTo string) {
+	con.Send("SET", cancelKey(replyTo), 1, "EX", c.config.responseTTL())
+	con.Send("DEL", replyTo)
+	if _, err := con.Do(""); err != nil {
+		c.log().Error().Err(err).Str("reply", replyTo).Msg("failed to cancel stream")
+	}
+}
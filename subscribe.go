@@ -0,0 +1,501 @@
+package zbus
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// streamHealthCheck is how often a subscription connection is pinged
+	streamHealthCheck = 30 * time.Second
+	// streamMaxBackoff is the max time to wait between reconnection attempts
+	streamMaxBackoff = 10 * time.Second
+)
+
+// StreamState is the connection state of an event stream
+type StreamState string
+
+const (
+	// StreamDisconnected the stream connection was lost, the
+	// client is trying to reconnect
+	StreamDisconnected StreamState = "disconnected"
+	// StreamReconnected the stream connection was restored. events
+	// published while the stream was disconnected are missed.
+	StreamReconnected StreamState = "reconnected"
+)
+
+// StreamStateHandler is called when the connection state of
+// the stream of key has changed. err is the reason of the disconnection
+type StreamStateHandler func(key string, state StreamState, err error)
+
+type streamStateKey struct{}
+
+// WithStreamStateHandler returns a context that makes all streams opened with
+// it report their connection state changes to handler
+func WithStreamStateHandler(ctx context.Context, handler StreamStateHandler) context.Context {
+	return context.WithValue(ctx, streamStateKey{}, handler)
+}
+
+func streamState(ctx context.Context, key string, state StreamState, err error) {
+	if handler, ok := ctx.Value(streamStateKey{}).(StreamStateHandler); ok {
+		handler(key, state, err)
+	}
+}
+
+// Message is an event received by a pattern subscription
+type Message struct {
+	// Key is the full event key `<module>.<object>.<event>`
+	Key string
+	// Event is the event data
+	Event Event
+}
+
+// Name returns the name of the event
+func (m *Message) Name() string {
+	return m.Key[strings.LastIndex(m.Key, ".")+1:]
+}
+
+// subscriber is a single stream listener. Received messages wait in the
+// subscriber queue until the subscriber takes them, so a slow subscriber
+// never holds back the other subscribers of the connection.
+type subscriber struct {
+	ctx      context.Context
+	key      string
+	pressure *BackPressure
+
+	queue []reflect.Value
+	m     sync.Mutex
+	// ready is signaled when a message is queued
+	ready chan struct{}
+}
+
+func newSubscriber(ctx context.Context, key string) *subscriber {
+	pressure, ok := backPressure(ctx)
+	if !ok {
+		// nothing is lost unless the caller asks for it
+		pressure = &BackPressure{Policy: OverflowBlock}
+	}
+
+	return &subscriber{
+		ctx:      ctx,
+		key:      key,
+		pressure: pressure,
+		ready:    make(chan struct{}, 1),
+	}
+}
+
+// deliver queues message according to the subscriber back pressure policy. It
+// never waits: with the block policy, messages are queued until the subscriber
+// takes them, however far behind it is.
+func (sub *subscriber) deliver(message redis.Message) {
+	sub.m.Lock()
+	if sub.pressure.Policy == OverflowBlock {
+		sub.queue = append(sub.queue, reflect.ValueOf(message))
+	} else {
+		sub.queue = sub.pressure.push(sub.queue, reflect.ValueOf(message))
+	}
+	sub.m.Unlock()
+
+	select {
+	case sub.ready <- struct{}{}:
+	default:
+	}
+}
+
+// next waits for the next queued message. Returns false once the
+// subscriber ctx is cancelled.
+func (sub *subscriber) next() (redis.Message, bool) {
+	for {
+		sub.m.Lock()
+		if len(sub.queue) > 0 {
+			message := sub.queue[0].Interface().(redis.Message)
+			sub.queue[0] = reflect.Value{}
+			sub.queue = sub.queue[1:]
+			sub.m.Unlock()
+			return message, true
+		}
+		sub.m.Unlock()
+
+		select {
+		case <-sub.ready:
+		case <-sub.ctx.Done():
+			return redis.Message{}, false
+		}
+	}
+}
+
+// subscriptions multiplexes all client streams over a single
+// pubsub connection, and fans out received events to subscribers.
+type subscriptions struct {
+	pool   *redis.Pool
+	logger *zerolog.Logger
+
+	channels map[string]map[*subscriber]struct{}
+	patterns map[string]map[*subscriber]struct{}
+	// con is the current connection, nil if not connected.
+	con redis.Conn
+	m   sync.Mutex
+	// wm serializes writes to the connection, since pubsub connections
+	// allow only one concurrent writer
+	wm sync.Mutex
+}
+
+func newSubscriptions(pool *redis.Pool, logger *zerolog.Logger) *subscriptions {
+	return &subscriptions{
+		pool:     pool,
+		logger:   logger,
+		channels: make(map[string]map[*subscriber]struct{}),
+		patterns: make(map[string]map[*subscriber]struct{}),
+	}
+}
+
+func (s *subscriptions) log() *zerolog.Logger {
+	return s.logger
+}
+
+func (s *subscriptions) write(con redis.Conn, command string, args ...interface{}) error {
+	s.wm.Lock()
+	defer s.wm.Unlock()
+
+	if err := con.Send(command, args...); err != nil {
+		return err
+	}
+
+	return con.Flush()
+}
+
+func (s *subscriptions) close(con redis.Conn) {
+	s.wm.Lock()
+	defer s.wm.Unlock()
+
+	con.Close()
+}
+
+// add registers a subscriber to a channel or (if pattern is true) a pattern. It
+// connects to the broker if this is the first subscriber.
+func (s *subscriptions) add(ctx context.Context, sub *subscriber, pattern bool) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	subs, command := s.channels, "SUBSCRIBE"
+	if pattern {
+		subs, command = s.patterns, "PSUBSCRIBE"
+	}
+
+	if s.con == nil && s.empty() {
+		// first subscriber, connect now so connection errors are
+		// reported to the caller
+		con, err := s.pool.GetContext(ctx)
+		if err != nil {
+			return err
+		}
+
+		s.con = con
+		go s.run(con)
+	}
+
+	if _, ok := subs[sub.key]; !ok {
+		subs[sub.key] = make(map[*subscriber]struct{})
+		if s.con != nil {
+			// if not connected, the subscription is sent after reconnection
+			if err := s.write(s.con, command, sub.key); err != nil {
+				s.log().Error().Err(err).Str("key", sub.key).Msg("failed to subscribe")
+			}
+		}
+	}
+
+	subs[sub.key][sub] = struct{}{}
+	return nil
+}
+
+// remove unregisters a subscriber, and unsubscribes from the channel (or pattern)
+// if it has no more subscribers
+func (s *subscriptions) remove(sub *subscriber, pattern bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	subs, command := s.channels, "UNSUBSCRIBE"
+	if pattern {
+		subs, command = s.patterns, "PUNSUBSCRIBE"
+	}
+
+	delete(subs[sub.key], sub)
+	if len(subs[sub.key]) != 0 {
+		return
+	}
+
+	delete(subs, sub.key)
+	if s.con != nil {
+		if err := s.write(s.con, command, sub.key); err != nil {
+			s.log().Error().Err(err).Str("key", sub.key).Msg("failed to unsubscribe")
+		}
+	}
+}
+
+// empty returns true if there are no subscribers. Must be called with lock held
+func (s *subscriptions) empty() bool {
+	return len(s.channels) == 0 && len(s.patterns) == 0
+}
+
+// all returns all subscribers. Must be called with lock held
+func (s *subscriptions) all() []*subscriber {
+	var all []*subscriber
+	for _, subs := range []map[string]map[*subscriber]struct{}{s.channels, s.patterns} {
+		for _, set := range subs {
+			for sub := range set {
+				all = append(all, sub)
+			}
+		}
+	}
+
+	return all
+}
+
+func (s *subscriptions) notify(state StreamState, err error) {
+	s.m.Lock()
+	subs := s.all()
+	s.m.Unlock()
+
+	for _, sub := range subs {
+		streamState(sub.ctx, sub.key, state, err)
+	}
+}
+
+// dispatch queues message to the subscribers of its channel (or pattern)
+func (s *subscriptions) dispatch(message redis.Message) {
+	s.m.Lock()
+	set := s.channels[message.Channel]
+	if len(message.Pattern) != 0 {
+		set = s.patterns[message.Pattern]
+	}
+
+	subs := make([]*subscriber, 0, len(set))
+	for sub := range set {
+		subs = append(subs, sub)
+	}
+	s.m.Unlock()
+
+	for _, sub := range subs {
+		sub.deliver(message)
+	}
+}
+
+// run receives messages from con, and reconnects if the connection is lost. It
+// exits once there are no more subscribers.
+func (s *subscriptions) run(con redis.Conn) {
+	for {
+		err := s.receive(con)
+
+		s.m.Lock()
+		if err == nil && !s.empty() {
+			// a subscriber was added after the last subscription
+			// was gone, it's subscribed over the same connection
+			s.m.Unlock()
+			continue
+		}
+
+		s.con = nil
+		empty := s.empty()
+		s.m.Unlock()
+
+		s.close(con)
+		if empty {
+			return
+		}
+
+		s.log().Error().Err(err).Msg("lost connection to event streams. reconnecting")
+		s.notify(StreamDisconnected, err)
+
+		if con = s.reconnect(); con == nil {
+			return
+		}
+
+		s.notify(StreamReconnected, nil)
+	}
+}
+
+// reconnect connects again and subscribes to all current channels and
+// patterns. Returns nil if there are no more subscribers.
+func (s *subscriptions) reconnect() redis.Conn {
+	backoff := 100 * time.Millisecond
+	for {
+		<-time.After(backoff)
+
+		s.m.Lock()
+		if s.empty() {
+			s.m.Unlock()
+			return nil
+		}
+
+		con, err := s.resubscribe()
+		if err == nil {
+			s.con = con
+			s.m.Unlock()
+			return con
+		}
+		s.m.Unlock()
+
+		s.log().Debug().Err(err).Msgf("failed to reconnect event streams, retrying in %s", backoff)
+		if backoff *= 2; backoff > streamMaxBackoff {
+			backoff = streamMaxBackoff
+		}
+	}
+}
+
+// resubscribe creates a new connection subscribed to all current
+// channels and patterns. Must be called with lock held
+func (s *subscriptions) resubscribe() (redis.Conn, error) {
+	con := s.pool.Get()
+	if err := con.Err(); err != nil {
+		con.Close()
+		return nil, err
+	}
+
+	for channel := range s.channels {
+		con.Send("SUBSCRIBE", channel)
+	}
+
+	for pattern := range s.patterns {
+		con.Send("PSUBSCRIBE", pattern)
+	}
+
+	if err := con.Flush(); err != nil {
+		con.Close()
+		return nil, err
+	}
+
+	return con, nil
+}
+
+// receive messages from con until the connection is broken (returns the error)
+// or there are no more subscribers (returns nil)
+func (s *subscriptions) receive(con redis.Conn) error {
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	defer func() {
+		close(done)
+		// make sure nothing is written to the connection after we return
+		<-exited
+	}()
+
+	go func() {
+		defer close(exited)
+		ticker := time.NewTicker(streamHealthCheck)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				s.write(con, "PING")
+			}
+		}
+	}()
+
+	psc := redis.PubSubConn{Conn: con}
+	for {
+		switch message := psc.ReceiveWithTimeout(2 * streamHealthCheck).(type) {
+		case redis.Message:
+			s.dispatch(message)
+		case redis.Subscription:
+			if message.Count != 0 {
+				continue
+			}
+
+			// all subscriptions are gone, we stop only if no new
+			// subscribers were added in the meantime
+			s.m.Lock()
+			empty := s.empty()
+			s.m.Unlock()
+			if empty {
+				return nil
+			}
+		case error:
+			return message
+		}
+	}
+}
+
+// subscribe starts a subscriber of key, and calls forward with every received
+// message until ctx is cancelled.
+func (c *RedisClient) subscribe(ctx context.Context, key string, pattern bool, forward func(redis.Message) bool, done func()) error {
+	sub := newSubscriber(ctx, key)
+
+	if err := c.subscriptions.add(ctx, sub, pattern); err != nil {
+		return err
+	}
+
+	go func() {
+		defer done()
+		defer c.subscriptions.remove(sub, pattern)
+
+		for {
+			message, ok := sub.next()
+			if !ok || !forward(message) {
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Stream listens to a stream of events from the server. All streams of a client share
+// a single connection to the broker. If the connection is lost, the client reconnects and
+// subscribes again. Connection state changes can be observed by passing a context
+// created with WithStreamStateHandler. Events wait in memory until the consumer takes them,
+// unless a back pressure policy that drops events is set with WithBackPressure on ctx. A slow
+// consumer doesn't hold back the other streams. The returned channel is closed once ctx is cancelled.
+func (c *RedisClient) Stream(ctx context.Context, module string, object ObjectID, event string) (<-chan Event, error) {
+	key := c.config.key(fmt.Sprintf("%s.%s.%s", module, object, event))
+
+	ch := make(chan Event)
+	forward := func(message redis.Message) bool {
+		select {
+		case ch <- Event(message.Data):
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	if err := c.subscribe(ctx, key, false, forward, func() { close(ch) }); err != nil {
+		return nil, err
+	}
+
+	return ch, nil
+}
+
+// StreamPattern listens to all events with keys that match the glob style pattern. For
+// example `<module>.*` receives all events of all objects of module. The returned
+// channel is closed once ctx is cancelled.
+func (c *RedisClient) StreamPattern(ctx context.Context, pattern string) (<-chan Message, error) {
+	ch := make(chan Message)
+	forward := func(message redis.Message) bool {
+		select {
+		case ch <- Message{Key: c.config.name(message.Channel), Event: Event(message.Data)}:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	if err := c.subscribe(ctx, c.config.pattern(pattern), true, forward, func() { close(ch) }); err != nil {
+		return nil, err
+	}
+
+	return ch, nil
+}
+
+// StreamObject listens to all events of object
+func (c *RedisClient) StreamObject(ctx context.Context, module string, object ObjectID) (<-chan Message, error) {
+	return c.StreamPattern(ctx, fmt.Sprintf("%s.%s.*", escapePattern(module), escapePattern(object.String())))
+}
@@ -0,0 +1,422 @@
+package zbus
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamStateHandler(t *testing.T) {
+	// no handler is set, must not panic
+	streamState(context.Background(), "key", StreamDisconnected, nil)
+
+	var states []StreamState
+	var keys []string
+	ctx := WithStreamStateHandler(context.Background(), func(key string, state StreamState, err error) {
+		keys = append(keys, key)
+		states = append(states, state)
+	})
+
+	streamState(ctx, "key", StreamDisconnected, fmt.Errorf("connection reset"))
+	streamState(ctx, "key", StreamReconnected, nil)
+
+	require.Equal(t, []string{"key", "key"}, keys)
+	require.Equal(t, []StreamState{StreamDisconnected, StreamReconnected}, states)
+}
+
+func TestMessageName(t *testing.T) {
+	message := Message{Key: "module.object@1.0.Event"}
+	require.Equal(t, "Event", message.Name())
+}
+
+func TestSubscriberDeliver(t *testing.T) {
+	message := func(data string) redis.Message {
+		return redis.Message{Channel: "key", Data: []byte(data)}
+	}
+
+	queued := func(sub *subscriber) []string {
+		sub.m.Lock()
+		defer sub.m.Unlock()
+
+		var data []string
+		for _, v := range sub.queue {
+			data = append(data, string(v.Interface().(redis.Message).Data))
+		}
+		return data
+	}
+
+	cases := []struct {
+		policy   OverflowPolicy
+		expected []string
+		dropped  uint64
+	}{
+		{OverflowDropNewest, []string{"a", "b"}, 1},
+		{OverflowDropOldest, []string{"b", "c"}, 1},
+		{OverflowCoalesce, []string{"c"}, 2},
+		// nothing is dropped, however slow the subscriber is
+		{OverflowBlock, []string{"a", "b", "c"}, 0},
+	}
+
+	for _, c := range cases {
+		t.Run(string(c.policy), func(t *testing.T) {
+			pressure := &BackPressure{Policy: c.policy, Buffer: 2}
+			sub := newSubscriber(WithBackPressure(context.Background(), pressure), "key")
+
+			for _, data := range []string{"a", "b", "c"} {
+				sub.deliver(message(data))
+			}
+
+			require.Equal(t, c.expected, queued(sub))
+			require.Equal(t, c.dropped, pressure.Dropped())
+		})
+	}
+
+	// by default nothing is dropped
+	ctx, cancel := context.WithCancel(context.Background())
+	sub := newSubscriber(ctx, "key")
+	for i := 0; i < 1000; i++ {
+		sub.deliver(message("a"))
+	}
+	require.Len(t, queued(sub), 1000)
+
+	next, ok := sub.next()
+	require.True(t, ok)
+	require.Equal(t, "a", string(next.Data))
+
+	// next waits for the next message until the subscriber is gone
+	sub = newSubscriber(ctx, "key")
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		sub.deliver(message("b"))
+	}()
+
+	next, ok = sub.next()
+	require.True(t, ok)
+	require.Equal(t, "b", string(next.Data))
+
+	cancel()
+	_, ok = sub.next()
+	require.False(t, ok)
+}
+
+// pubsubBroker is a fake broker that serves pubsub connections, and
+// can drop them all as if the broker was restarted
+type pubsubBroker struct {
+	m     sync.Mutex
+	conns map[*pubsubConn]struct{}
+	dials int
+}
+
+func newPubsubBroker() *pubsubBroker {
+	return &pubsubBroker{conns: make(map[*pubsubConn]struct{})}
+}
+
+func (b *pubsubBroker) pool() *redis.Pool {
+	return &redis.Pool{Dial: func() (redis.Conn, error) {
+		b.m.Lock()
+		defer b.m.Unlock()
+
+		con := &pubsubConn{
+			broker:   b,
+			channels: make(map[string]bool),
+			patterns: make(map[string]bool),
+			replies:  make(chan interface{}, 4096),
+			broken:   make(chan struct{}),
+		}
+		b.conns[con] = struct{}{}
+		b.dials++
+		return con, nil
+	}}
+}
+
+func (b *pubsubBroker) publish(channel, data string) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	for con := range b.conns {
+		con.m.Lock()
+		if con.channels[channel] {
+			con.replies <- []interface{}{[]byte("message"), []byte(channel), []byte(data)}
+		}
+		for pattern := range con.patterns {
+			if ok, _ := path.Match(pattern, channel); ok {
+				con.replies <- []interface{}{[]byte("pmessage"), []byte(pattern), []byte(channel), []byte(data)}
+			}
+		}
+		con.m.Unlock()
+	}
+}
+
+// drop breaks all the open connections
+func (b *pubsubBroker) drop() {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	for con := range b.conns {
+		con.breakOnce.Do(func() { close(con.broken) })
+		delete(b.conns, con)
+	}
+}
+
+// subscribed returns the channels and patterns subscribed on the open connections
+func (b *pubsubBroker) subscribed() []string {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	var keys []string
+	for con := range b.conns {
+		con.m.Lock()
+		for channel := range con.channels {
+			keys = append(keys, channel)
+		}
+		for pattern := range con.patterns {
+			keys = append(keys, pattern)
+		}
+		con.m.Unlock()
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+type pubsubConn struct {
+	redis.Conn
+	broker *pubsubBroker
+
+	m         sync.Mutex
+	channels  map[string]bool
+	patterns  map[string]bool
+	replies   chan interface{}
+	broken    chan struct{}
+	breakOnce sync.Once
+}
+
+func (c *pubsubConn) Send(cmd string, args ...interface{}) error {
+	if c.Err() != nil {
+		return c.Err()
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	kind := []byte(strings.ToLower(cmd))
+	switch cmd {
+	case "SUBSCRIBE", "PSUBSCRIBE", "UNSUBSCRIBE", "PUNSUBSCRIBE":
+		set := c.channels
+		if strings.HasPrefix(cmd, "P") {
+			set = c.patterns
+		}
+
+		for _, arg := range args {
+			key := arg.(string)
+			if strings.Contains(cmd, "UNSUBSCRIBE") {
+				delete(set, key)
+			} else {
+				set[key] = true
+			}
+			c.replies <- []interface{}{kind, []byte(key), int64(len(c.channels) + len(c.patterns))}
+		}
+
+		if len(args) == 0 {
+			for key := range set {
+				delete(set, key)
+			}
+			c.replies <- []interface{}{kind, nil, int64(len(c.channels) + len(c.patterns))}
+		}
+	case "PING":
+		c.replies <- []interface{}{[]byte("pong"), []byte("")}
+	case "ECHO":
+		c.replies <- args[0]
+	}
+
+	return nil
+}
+
+func (c *pubsubConn) Flush() error {
+	return c.Err()
+}
+
+func (c *pubsubConn) Receive() (interface{}, error) {
+	select {
+	case reply := <-c.replies:
+		return reply, nil
+	case <-c.broken:
+		return nil, io.EOF
+	}
+}
+
+func (c *pubsubConn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	return c.Receive()
+}
+
+func (c *pubsubConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	return nil, c.Err()
+}
+
+func (c *pubsubConn) DoWithTimeout(timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	return c.Do(cmd, args...)
+}
+
+func (c *pubsubConn) Err() error {
+	select {
+	case <-c.broken:
+		return io.EOF
+	default:
+		return nil
+	}
+}
+
+func (c *pubsubConn) Close() error {
+	c.broker.m.Lock()
+	defer c.broker.m.Unlock()
+
+	delete(c.broker.conns, c)
+	return nil
+}
+
+func newPubsubClient(broker *pubsubBroker) *RedisClient {
+	pool := broker.pool()
+	config := newConfig(nil)
+	return &RedisClient{pool: pool, config: config, subscriptions: newSubscriptions(pool, config.log())}
+}
+
+// receiveEvent waits for an event on ch
+func receiveEvent(t *testing.T, ch <-chan Event) string {
+	select {
+	case event := <-ch:
+		return string(event)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event")
+		return ""
+	}
+}
+
+func TestStreamsShareConnection(t *testing.T) {
+	broker := newPubsubBroker()
+	client := newPubsubClient(broker)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	object := ObjectID{Name: "object"}
+	a, err := client.Stream(ctx, "module", object, "A")
+	require.NoError(t, err)
+	b, err := client.Stream(ctx, "module", object, "B")
+	require.NoError(t, err)
+	all, err := client.StreamObject(ctx, "module", object)
+	require.NoError(t, err)
+
+	require.Equal(t, 1, broker.dials)
+	require.Eventually(t, func() bool {
+		return len(broker.subscribed()) == 3
+	}, time.Second, 10*time.Millisecond)
+
+	broker.publish("module.object.A", "a")
+	broker.publish("module.object.B", "b")
+
+	require.Equal(t, "a", receiveEvent(t, a))
+	require.Equal(t, "b", receiveEvent(t, b))
+
+	for _, expected := range []string{"module.object.A", "module.object.B"} {
+		select {
+		case message := <-all:
+			require.Equal(t, expected, message.Key)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for event")
+		}
+	}
+
+	// once all streams are gone, the connection is closed
+	cancel()
+	require.Eventually(t, func() bool {
+		broker.m.Lock()
+		defer broker.m.Unlock()
+		return len(broker.conns) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestStreamsReconnect(t *testing.T) {
+	broker := newPubsubBroker()
+	client := newPubsubClient(broker)
+
+	states := make(chan StreamState, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx = WithStreamStateHandler(ctx, func(key string, state StreamState, err error) {
+		if key == "module.object.A" {
+			states <- state
+		}
+	})
+
+	object := ObjectID{Name: "object"}
+	a, err := client.Stream(ctx, "module", object, "A")
+	require.NoError(t, err)
+	all, err := client.StreamObject(ctx, "module", object)
+	require.NoError(t, err)
+
+	broker.publish("module.object.A", "before")
+	require.Equal(t, "before", receiveEvent(t, a))
+	<-all
+
+	broker.drop()
+	require.Equal(t, StreamDisconnected, <-states)
+	require.Equal(t, StreamReconnected, <-states)
+
+	// all streams are subscribed again on the new connection
+	require.Equal(t, 2, broker.dials)
+	require.Eventually(t, func() bool {
+		return len(broker.subscribed()) == 2
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, []string{"module.object.*", "module.object.A"}, broker.subscribed())
+
+	broker.publish("module.object.A", "after")
+	require.Equal(t, "after", receiveEvent(t, a))
+
+	select {
+	case message := <-all:
+		require.Equal(t, Event("after"), message.Event)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+}
+
+func TestStreamsSlowSubscriber(t *testing.T) {
+	broker := newPubsubBroker()
+	client := newPubsubClient(broker)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	object := ObjectID{Name: "object"}
+	slow, err := client.Stream(ctx, "module", object, "A")
+	require.NoError(t, err)
+	fast, err := client.Stream(ctx, "module", object, "B")
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return len(broker.subscribed()) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	// nobody receives from the slow stream
+	const count = 1000
+	for i := 0; i < count; i++ {
+		broker.publish("module.object.A", fmt.Sprint(i))
+	}
+	broker.publish("module.object.B", "b")
+
+	require.Equal(t, "b", receiveEvent(t, fast))
+
+	// the slow stream doesn't lose anything
+	for i := 0; i < count; i++ {
+		require.Equal(t, fmt.Sprint(i), receiveEvent(t, slow))
+	}
+}
@@ -7,3 +7,19 @@ type Server interface {
 	Register(id ObjectID, object interface{}) error
 	Run(ctx context.Context) error
 }
+
+// ConfigurableServer is implemented by servers that accept options on
+// how an object is served
+type ConfigurableServer interface {
+	// RegisterWith registers an object with options
+	RegisterWith(id ObjectID, object interface{}, opts ...ObjectOption) error
+}
+
+// DynamicServer is implemented by servers that can change their
+// objects while running
+type DynamicServer interface {
+	// Unregister removes an object from the server
+	Unregister(id ObjectID) error
+	// Replace swaps the implementation of a registered object
+	Replace(id ObjectID, object interface{}) error
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/threefoldtech/zbus"
+)
+
+func usage() {
+	log.Println("Usage: zbusctl [flags] <command> [arguments]")
+	log.Println("commands:")
+	log.Println("	dead list <module>               list dead letters of module")
+	log.Println("	dead requeue <module> [count]    requeue the oldest count dead letters (default all)")
+	log.Println("	dead purge <module>              delete all dead letters of module")
+	log.Println("flags:")
+	flag.PrintDefaults()
+}
+
+func describe(letter *zbus.DeadLetter) string {
+	request, err := letter.Request()
+	if err != nil {
+		return fmt.Sprintf("%d bytes undecodable payload", len(letter.Payload))
+	}
+
+	return fmt.Sprintf("[%s] %s.%s()", request.ID, request.Object, request.Method)
+}
+
+func deadList(ctx context.Context, client *zbus.RedisClient, module string) error {
+	letters, err := client.DeadLetters(ctx, module)
+	if err != nil {
+		return err
+	}
+
+	for i := range letters {
+		letter := &letters[i]
+		fmt.Printf("%s %s %s: %s", letter.Time.Format(time.RFC3339), letter.Queue, letter.Reason, describe(letter))
+		if len(letter.Message) != 0 {
+			fmt.Printf(" (%s)", letter.Message)
+		}
+		fmt.Println()
+	}
+
+	return nil
+}
+
+func dead(ctx context.Context, client *zbus.RedisClient, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("missing dead letter command or module")
+	}
+
+	command, module := args[0], args[1]
+	switch command {
+	case "list":
+		return deadList(ctx, client, module)
+	case "requeue":
+		count := 0
+		if len(args) > 2 {
+			var err error
+			if count, err = strconv.Atoi(args[2]); err != nil || count < 0 {
+				return fmt.Errorf("invalid count '%s'", args[2])
+			}
+		}
+		requeued, err := client.Requeue(ctx, module, count)
+		fmt.Printf("requeued %d request(s)\n", requeued)
+		return err
+	case "purge":
+		return client.PurgeDeadLetters(ctx, module)
+	default:
+		return fmt.Errorf("unknown dead letter command '%s'", command)
+	}
+}
+
+func main() {
+	var broker, namespace string
+	flag.StringVar(&broker, "broker", "tcp://localhost:6379", "message broker url")
+	flag.StringVar(&namespace, "namespace", "", "namespace of the modules")
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		usage()
+		os.Exit(1)
+	}
+
+	client, err := zbus.NewRedisClient(broker, zbus.WithNamespace(namespace))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "dead":
+		err = dead(ctx, client.(*zbus.RedisClient), args[1:])
+	default:
+		usage()
+		os.Exit(1)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}